@@ -13,12 +13,16 @@ Internal definitions may change without notice.
 load(
     "//internal:rules.bzl",
     _go_binary = "go_binary",
+    _go_config_independence_test = "go_config_independence_test",
+    _go_cross_binary = "go_cross_binary",
     _go_library = "go_library",
     _go_test = "go_test",
+    _nogo = "nogo",
 )
 load(
     "//internal:providers.bzl",
     _GoLibraryInfo = "GoLibraryInfo",
+    _NogoInfo = "NogoInfo",
 )
 load(
     "//internal:toolchain.bzl",
@@ -26,7 +30,11 @@ load(
 )
 
 go_binary = _go_binary
+go_config_independence_test = _go_config_independence_test
+go_cross_binary = _go_cross_binary
 go_library = _go_library
 go_test = _go_test
 go_toolchain = _go_toolchain
+nogo = _nogo
 GoLibraryInfo = _GoLibraryInfo
+NogoInfo = _NogoInfo
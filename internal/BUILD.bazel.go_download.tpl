@@ -8,16 +8,20 @@ load(
     "go_tool_binary",
 )
 
-# tools contains executable files that are part of the toolchain.
+# tools contains executable files that are part of the toolchain, as well as
+# headers in pkg/include that assembly files may include.
 filegroup(
     name = "tools",
-    srcs = ["bin/go{exe}"] + glob(["pkg/tool/{goos}_{goarch}/**"]),
+    srcs = ["bin/go{exe}"] + glob([
+        "pkg/include/**",
+        "pkg/tool/{goos}_{goarch}/**",
+    ]),
     visibility = ["//visibility:public"],
 )
 
-# stdlib compiles packages in the standard library.
-go_stdlib(
-    name = "stdlib",
+# stdlib_srcs contains source files for packages in the standard library.
+filegroup(
+    name = "stdlib_srcs",
     srcs = glob(
         [
             "src/**",
@@ -25,7 +29,19 @@ go_stdlib(
         ],
         exclude = ["src/cmd/**"],
     ),
-    tools = [":tools"],
+)
+
+# stdlib_<goos>_<goarch> targets compile packages in the standard library
+# for each supported target platform. stdlib_<goos>_<goarch>_race, _msan, and
+# _asan targets compile the same packages instrumented for the race detector,
+# memory sanitizer, and address sanitizer.
+{stdlibs}
+
+# stdlib is the standard library compiled for the platform this distribution
+# runs on. It's needed to build the builder.
+alias(
+    name = "stdlib",
+    actual = ":stdlib_{goos}_{goarch}",
     visibility = ["//visibility:public"],
 )
 
@@ -10,7 +10,31 @@ call functions to create actions. This allows action code to be shared
 by multiple rules.
 """
 
-def go_compile(ctx, *, srcs, importpath, deps, out):
+load("@bazel_skylib//lib:paths.bzl", "paths")
+load("@bazel_tools//tools/build_defs/cc:action_names.bzl", "ACTION_NAMES")
+load("@bazel_tools//tools/cpp:toolchain_utils.bzl", "find_cpp_toolchain")
+load(":util.bzl", "check_sanitizer_compiler")
+
+# Coverage counter mode used when building with "bazel coverage". LCOV only
+# needs to know which lines ran, so we don't need to count executions.
+# With the race detector, counters must be updated atomically, like the go
+# command does.
+_COVER_MODE = "set"
+_RACE_COVER_MODE = "atomic"
+
+# Execution requirements for actions run by the builder. The builder may run
+# as a persistent worker, handling many requests concurrently in one process,
+# which saves start-up time and lets it cache the standard library listing and
+# parsed sources between actions. Workers need arguments in a params file.
+# Multiplex sandboxing isn't supported: requests share the worker's working
+# directory, so the builder rejects requests with a sandbox directory.
+_BUILDER_EXECUTION_REQUIREMENTS = {
+    "supports-workers": "1",
+    "supports-multiplex-workers": "1",
+    "requires-worker-protocol": "json",
+}
+
+def go_compile(ctx, *, srcs, importpath, deps, out, importmap = "", embedsrcs = [], gotags = [], go_version = "", gomod = None, gc_goopts = [], cover = False, cgo = False, cdeps = [], unused_deps_out = None, unused_inputs_out = None, facts_out = None, export_out = None):
     """Compiles a single Go package from sources.
 
     Args:
@@ -19,33 +43,106 @@ def go_compile(ctx, *, srcs, importpath, deps, out):
         importpath: the path other libraries may use to import this package.
         deps: list of GoLibraryInfo objects for direct dependencies.
         out: output .a File.
+        importmap: the package path to compile with, if different from
+            importpath. Must be unique among packages linked into a binary.
+        embedsrcs: list of Files that may be embedded with //go:embed.
+        gotags: list of build tags used to filter srcs.
+        go_version: Go language version to compile for, like "1.21".
+        gomod: go.mod File whose go directive sets the language version
+            if go_version is empty.
+        gc_goopts: list of additional flags for the Go compiler.
+        cover: whether to instrument srcs for coverage.
+        cgo: whether the package may contain cgo code, C, or C++ sources.
+        cdeps: list of CcInfo objects for C/C++ dependencies. Only used
+            if cgo is True.
+        unused_deps_out: optional output JSON File listing direct
+            dependencies that no source imports.
+        unused_inputs_out: optional output File listing dependency and
+            standard library archives the compiler didn't read. If not set,
+            a file is declared next to out.
+        facts_out: optional output File where nogo analyzers write facts
+            about the package. Only used if nogo is enabled.
+        export_out: optional output File containing only the package's
+            export data, which packages that import it are compiled against.
+            If set, out only contains code for the linker.
     """
     toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
 
-    args = ctx.actions.args()
-    args.add("compile")
-    args.add("-stdlib", toolchain.internal.stdlib.path)
+    args = _builder_args(ctx, "compile")
+    args.add("-stdimportcfg", toolchain.internal.stdlib.importcfg)
+    args.add("-goos", toolchain.internal.goos)
+    args.add("-goarch", toolchain.internal.goarch)
+    args.add("-label", _format_label(ctx.label))
     dep_infos = [d.info for d in deps]
-    args.add_all(dep_infos, before_each = "-arc", map_each = _format_arc)
-    if importpath:
-        args.add("-p", importpath)
+    args.add_all(dep_infos, before_each = "-arc", map_each = _format_export_arc)
+    args.add_all(
+        depset(dep_infos, transitive = [d.deps for d in deps]),
+        before_each = "-deplabel",
+        map_each = _format_deplabel,
+    )
+    args.add("-unuseddeps", toolchain.internal.unused_deps)
+    unused_inputs = unused_inputs_out or _declare_unused_inputs_file(ctx, out)
+    args.add("-unusedinputsout", unused_inputs)
+    outputs = [out, unused_inputs]
+    if unused_deps_out:
+        args.add("-unuseddepsout", unused_deps_out)
+        outputs.append(unused_deps_out)
+    nogo_inputs = _add_nogo_args(args, toolchain, dep_infos, facts_out, outputs)
+    if importmap or importpath:
+        args.add("-p", importmap or importpath)
     args.add("-o", out)
-    args.add_all(srcs)
+    if export_out:
+        args.add("-exportout", export_out)
+        outputs.append(export_out)
+    args.add_all(_format_embedsrcs(ctx, embedsrcs), before_each = "-embedsrc")
+    if gotags:
+        args.add_joined("-tags", gotags, join_with = ",")
+    _add_lang_args(args, go_version, gomod)
+    args.add_all(gc_goopts, before_each = "-goopt")
+    _add_pgoprofile_args(args, toolchain)
+    if cover:
+        args.add("-covermode", _cover_mode(toolchain))
+    _add_instrumentation_args(args, toolchain)
+    tool_names = ["compile"]
+    if any([src.extension in ("s", "S", "sx") for src in srcs]):
+        tool_names.append("asm")
+    if cgo:
+        tool_names.append("cgo")
+    if cover:
+        tool_names.append("cover")
+    tool_inputs = _add_tool_args(args, toolchain, tool_names)
 
     inputs = (srcs +
-              [dep.info.archive for dep in deps] +
-              [toolchain.internal.stdlib] +
-              toolchain.internal.tools)
+              embedsrcs +
+              ([gomod] if gomod else []) +
+              _pgoprofile_inputs(toolchain) +
+              nogo_inputs +
+              [dep.info.export for dep in deps] +
+              [toolchain.internal.stdlib.importcfg] +
+              tool_inputs)
+    transitive_inputs = [toolchain.internal.stdlib.pkgs]
+    if cgo:
+        cgo_config = _cgo_config(ctx, cdeps)
+        args.add("-cc", cgo_config.cc)
+        args.add_all(cgo_config.cppflags, before_each = "-cppflag")
+        args.add_all(cgo_config.cflags, before_each = "-cflag")
+        args.add_all(cgo_config.cxxflags, before_each = "-cxxflag")
+        args.add_all(cgo_config.ldflags, before_each = "-ldflag")
+        transitive_inputs.append(cgo_config.inputs)
+    args.add_all(srcs)
+
     ctx.actions.run(
-        outputs = [out],
-        inputs = inputs,
+        outputs = outputs,
+        inputs = depset(inputs, transitive = transitive_inputs),
         executable = toolchain.internal.builder,
         arguments = [args],
         env = toolchain.internal.env,
+        execution_requirements = _BUILDER_EXECUTION_REQUIREMENTS,
+        unused_inputs_list = unused_inputs,
         mnemonic = "GoCompile",
     )
 
-def go_link(ctx, *, main, deps, out):
+def go_link(ctx, *, main, deps, out, gc_linkopts = [], cgo = False, cdeps = []):
     """Links a Go executable.
 
     Args:
@@ -53,6 +150,10 @@ def go_link(ctx, *, main, deps, out):
         main: archive file for the main package.
         deps: list of GoLibraryInfo objects for direct dependencies.
         out: output executable file.
+        gc_linkopts: list of additional flags for the Go linker.
+        cgo: whether the main package was compiled with cgo.
+        cdeps: list of CcInfo objects for C/C++ dependencies of the
+            main package.
     """
     toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
 
@@ -60,27 +161,35 @@ def go_link(ctx, *, main, deps, out):
         direct = [d.info for d in deps],
         transitive = [d.deps for d in deps],
     )
-    inputs = ([main, toolchain.internal.stdlib] +
+    args = _builder_args(ctx, "link")
+    inputs = ([main, toolchain.internal.stdlib.importcfg] +
               [d.archive for d in transitive_deps.to_list()] +
-              toolchain.internal.tools)
-
-    args = ctx.actions.args()
-    args.add("link")
-    args.add("-stdlib", toolchain.internal.stdlib.path)
+              _add_tool_args(args, toolchain, ["link"]))
+    args.add("-stdimportcfg", toolchain.internal.stdlib.importcfg)
+    args.add("-goos", toolchain.internal.goos)
+    args.add("-goarch", toolchain.internal.goarch)
     args.add_all(transitive_deps, before_each = "-arc", map_each = _format_arc)
     args.add("-main", main)
     args.add("-o", out)
+    unused_inputs = _declare_unused_inputs_file(ctx, out)
+    args.add("-unusedinputsout", unused_inputs)
+    args.add_all(gc_linkopts, before_each = "-linkopt")
+    _add_instrumentation_args(args, toolchain)
+    transitive_inputs = [toolchain.internal.stdlib.pkgs]
+    transitive_inputs += _add_cgo_link_args(ctx, args, transitive_deps, cgo, cdeps)
 
     ctx.actions.run(
-        outputs = [out],
-        inputs = inputs,
+        outputs = [out, unused_inputs],
+        inputs = depset(inputs, transitive = transitive_inputs),
         executable = toolchain.internal.builder,
         arguments = [args],
         env = toolchain.internal.env,
+        execution_requirements = _BUILDER_EXECUTION_REQUIREMENTS,
+        unused_inputs_list = unused_inputs,
         mnemonic = "GoLink",
     )
 
-def go_build_test(ctx, *, srcs, deps, rundir, importpath, out):
+def go_build_test(ctx, *, srcs, deps, rundir, importpath, out, embedsrcs = [], gotags = [], go_version = "", gomod = None, gc_goopts = [], gc_linkopts = [], cover = False, vet = ""):
     """Compiles and links a Go test executable.
 
     Args:
@@ -90,37 +199,367 @@ def go_build_test(ctx, *, srcs, deps, rundir, importpath, out):
         importpath: import path of the internal test archive.
         rundir: directory the test should change to before executing.
         out: output executable file.
+        embedsrcs: list of Files that may be embedded with //go:embed.
+        gotags: list of build tags used to filter srcs.
+        go_version: Go language version to compile srcs for, like "1.21".
+        gomod: go.mod File whose go directive sets the language version
+            if go_version is empty.
+        gc_goopts: list of additional flags for the Go compiler.
+        gc_linkopts: list of additional flags for the Go linker.
+        cover: whether to instrument non-test srcs for coverage. When
+            building with "bazel coverage", the test reports coverage for
+            all instrumented packages, even if this is False.
+        vet: comma-separated list of vet checks to run on srcs before
+            linking. If empty, the checks "go test" runs by default are
+            used. "all" runs every check, and "off" disables vet.
     """
     toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
     direct_dep_infos = [d.info for d in deps]
-    transitive_dep_infos = depset(transitive = [d.deps for d in deps]).to_list()
+    transitive_dep_infos = depset(
+        direct = direct_dep_infos,
+        transitive = [d.deps for d in deps],
+    )
     inputs = (srcs +
-              [toolchain.internal.stdlib] +
-              [d.archive for d in direct_dep_infos] +
-              [d.archive for d in transitive_dep_infos] +
-              toolchain.internal.tools)
+              embedsrcs +
+              ([gomod] if gomod else []) +
+              _pgoprofile_inputs(toolchain) +
+              [toolchain.internal.stdlib.importcfg] +
+              [d.export for d in direct_dep_infos] +
+              [d.archive for d in transitive_dep_infos.to_list()])
 
-    args = ctx.actions.args()
-    args.add("test")
-    args.add("-stdlib", toolchain.internal.stdlib.path)
-    args.add_all(direct_dep_infos, before_each = "-direct", map_each = _format_arc)
+    args = _builder_args(ctx, "test")
+    args.add("-stdimportcfg", toolchain.internal.stdlib.importcfg)
+    args.add("-goos", toolchain.internal.goos)
+    args.add("-goarch", toolchain.internal.goarch)
+    args.add_all(direct_dep_infos, before_each = "-direct", map_each = _format_export_arc)
     args.add_all(transitive_dep_infos, before_each = "-transitive", map_each = _format_arc)
     if rundir != "":
         args.add("-dir", rundir)
     if importpath != "":
         args.add("-p", importpath)
     args.add("-o", out)
+    unused_inputs = _declare_unused_inputs_file(ctx, out)
+    args.add("-unusedinputsout", unused_inputs)
+    outputs = [out, unused_inputs]
+
+    # Test sources are checked with nogo like library sources, but facts
+    # about test packages aren't saved.
+    inputs += _add_nogo_args(args, toolchain, direct_dep_infos, None, outputs)
+    args.add_all(_format_embedsrcs(ctx, embedsrcs), before_each = "-embedsrc")
+    if gotags:
+        args.add_joined("-tags", gotags, join_with = ",")
+    _add_lang_args(args, go_version, gomod)
+    args.add_all(gc_goopts, before_each = "-goopt")
+    args.add_all(gc_linkopts, before_each = "-linkopt")
+    if vet:
+        args.add("-vet", vet)
+    _add_pgoprofile_args(args, toolchain)
+    tool_names = ["compile", "link"]
+    if vet != "off":
+        tool_names.append("vet")
+    if ctx.configuration.coverage_enabled:
+        args.add("-covermode", _cover_mode(toolchain))
+        args.add("-testmaincover", toolchain.internal.testmain_cover)
+        inputs.append(toolchain.internal.testmain_cover)
+        if cover:
+            args.add("-cover")
+            tool_names.append("cover")
+    _add_instrumentation_args(args, toolchain)
+    inputs += _add_tool_args(args, toolchain, tool_names)
+    transitive_inputs = [toolchain.internal.stdlib.pkgs]
+    transitive_inputs += _add_cgo_link_args(
+        ctx,
+        args,
+        transitive_dep_infos,
+        cgo = False,
+        cdeps = [],
+    )
     args.add_all(srcs)
 
     ctx.actions.run(
-        outputs = [out],
-        inputs = inputs,
+        outputs = outputs,
+        inputs = depset(inputs, transitive = transitive_inputs),
         executable = toolchain.internal.builder,
         arguments = [args],
         env = toolchain.internal.env,
+        execution_requirements = _BUILDER_EXECUTION_REQUIREMENTS,
+        unused_inputs_list = unused_inputs,
         mnemonic = "GoTest",
     )
 
+def _builder_args(ctx, verb):
+    """Returns an Args object for a builder action running verb.
+
+    Arguments are always passed in a params file so the action may run in
+    a persistent worker.
+    """
+    args = ctx.actions.args()
+    args.use_param_file("@%s", use_always = True)
+    args.set_param_file_format("multiline")
+    args.add(verb)
+    return args
+
+def _add_tool_args(args, toolchain, names):
+    """Adds -tool arguments for tools from the Go distribution the builder runs.
+
+    Returns:
+        A list of Files needed as inputs: the named tools, plus headers the
+        assembler may include if "asm" is one of them.
+    """
+    inputs = []
+    for name in names:
+        tool = toolchain.internal.tools.get(name)
+        if not tool:
+            fail("Go distribution does not contain the {} tool".format(name))
+        args.add("-tool", "{}={}".format(name, tool.path))
+        inputs.append(tool)
+    if "asm" in names:
+        inputs.extend(toolchain.internal.asm_headers)
+    return inputs
+
+def _declare_unused_inputs_file(ctx, out):
+    """Declares a file where the builder lists archives an action didn't read.
+
+    The file is passed to ctx.actions.run as unused_inputs_list. Bazel ignores
+    changes to listed files when checking whether the action must run again,
+    so editing a dependency that isn't linked into a binary doesn't relink it.
+    Both dependency and standard library archives are listed; go_stdlib
+    declares a file for each standard library package so this works.
+    Bazel only consults the list for its local action cache: remote cache
+    keys still include every input.
+    """
+    return ctx.actions.declare_file(out.basename + ".unused_inputs", sibling = out)
+
+def _cover_mode(toolchain):
+    """Returns the coverage counter mode for packages built with toolchain."""
+    return _RACE_COVER_MODE if toolchain.internal.race else _COVER_MODE
+
+def _add_lang_args(args, go_version, gomod):
+    """Adds arguments that set the Go language version.
+
+    An explicit go_version takes precedence. Otherwise, the builder reads
+    the version from the go directive in gomod.
+    """
+    if go_version:
+        args.add("-lang", go_version)
+    elif gomod:
+        args.add("-gomod", gomod)
+
+def _add_pgoprofile_args(args, toolchain):
+    """Adds arguments for profile-guided optimization, if enabled."""
+    if toolchain.internal.pgoprofile:
+        args.add("-pgoprofile", toolchain.internal.pgoprofile)
+
+def _pgoprofile_inputs(toolchain):
+    """Returns a list of inputs needed for profile-guided optimization."""
+    return [toolchain.internal.pgoprofile] if toolchain.internal.pgoprofile else []
+
+def _add_nogo_args(args, toolchain, dep_infos, facts_out, outputs):
+    """Adds arguments for running nogo after a package is compiled.
+
+    Args:
+        args: Args object for a compile or test action.
+        toolchain: the Go toolchain.
+        dep_infos: list of GoLibraryInfo.info structs for direct dependencies.
+        facts_out: output File for facts about the package, or None.
+        outputs: list of the action's outputs. facts_out is appended.
+    Returns:
+        A list of additional input Files for the action.
+    """
+    nogo = toolchain.internal.nogo
+    if not nogo:
+        return []
+    args.add("-nogo", nogo.executable)
+    inputs = [nogo.executable]
+    if nogo.config:
+        args.add("-nogoconfig", nogo.config)
+        inputs.append(nogo.config)
+    if facts_out:
+        args.add("-factsout", facts_out)
+        outputs.append(facts_out)
+    for info in dep_infos:
+        if info.facts:
+            args.add("-depfacts", "{}={}".format(info.importmap, info.facts.path))
+            inputs.append(info.facts)
+    return inputs
+
+def _add_instrumentation_args(args, toolchain):
+    """Adds arguments that enable the race detector or a sanitizer."""
+    if toolchain.internal.race:
+        args.add("-race")
+    if toolchain.internal.sanitizer:
+        args.add("-sanitizer", toolchain.internal.sanitizer)
+
+def _add_cgo_link_args(ctx, args, dep_infos, cgo, cdeps):
+    """Adds arguments for external linking if any linked package uses cgo.
+
+    Sanitizer runtimes are written in C, so external linking is also needed
+    when a sanitizer is enabled.
+
+    Args:
+        ctx: analysis context.
+        args: Args object for a link or test action.
+        dep_infos: depset of GoLibraryInfo.info structs for all packages
+            being linked, other than the main package.
+        cgo: whether the main package was compiled with cgo.
+        cdeps: list of CcInfo objects for C/C++ dependencies of the
+            main package.
+    Returns:
+        A list of depsets of additional inputs for the action.
+    """
+    toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
+    dep_info_list = dep_infos.to_list()
+    if (not cgo and
+        not toolchain.internal.sanitizer and
+        not any([info.cgo for info in dep_info_list])):
+        return []
+    cc_infos = cdeps + [info.cc_info for info in dep_info_list if info.cc_info]
+    cgo_config = _cgo_config(ctx, cc_infos)
+    args.add("-cc", cgo_config.cc)
+    args.add_all(cgo_config.ldflags, before_each = "-ldflag")
+    return [cgo_config.inputs]
+
+def _cgo_config(ctx, cc_infos):
+    """Gathers the C/C++ toolchain configuration needed to build with cgo.
+
+    Args:
+        ctx: analysis context. The rule must request the C++ toolchain.
+        cc_infos: list of CcInfo objects for C/C++ dependencies.
+    Returns:
+        A struct with the following fields:
+            cc: path to the C/C++ compiler, also used as the linker.
+            cppflags: preprocessor flags for C and C++ sources.
+            cflags: compiler flags for C sources.
+            cxxflags: compiler flags for C++ sources.
+            ldflags: linker flags, including C/C++ libraries to link.
+            inputs: depset of Files needed to run the compiler and linker.
+    """
+    cc_toolchain = find_cpp_toolchain(ctx, mandatory = False)
+    if not cc_toolchain:
+        fail("{}: cgo requires a C/C++ toolchain, but none was found".format(ctx.label))
+    toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
+    check_sanitizer_compiler(ctx.label, toolchain.internal.sanitizer, cc_toolchain)
+    feature_configuration = cc_common.configure_features(
+        ctx = ctx,
+        cc_toolchain = cc_toolchain,
+        requested_features = ctx.features,
+        unsupported_features = ctx.disabled_features,
+    )
+    compile_variables = cc_common.create_compile_variables(
+        feature_configuration = feature_configuration,
+        cc_toolchain = cc_toolchain,
+    )
+    link_variables = cc_common.create_link_variables(
+        feature_configuration = feature_configuration,
+        cc_toolchain = cc_toolchain,
+        is_linking_dynamic_library = False,
+    )
+    cc = cc_common.get_tool_for_action(
+        feature_configuration = feature_configuration,
+        action_name = ACTION_NAMES.c_compile,
+    )
+    cflags = cc_common.get_memory_inefficient_command_line(
+        feature_configuration = feature_configuration,
+        action_name = ACTION_NAMES.c_compile,
+        variables = compile_variables,
+    )
+    cxxflags = cc_common.get_memory_inefficient_command_line(
+        feature_configuration = feature_configuration,
+        action_name = ACTION_NAMES.cpp_compile,
+        variables = compile_variables,
+    )
+    ldflags = cc_common.get_memory_inefficient_command_line(
+        feature_configuration = feature_configuration,
+        action_name = ACTION_NAMES.cpp_link_executable,
+        variables = link_variables,
+    )
+
+    # Translate headers and libraries from C/C++ dependencies into flags.
+    cc_info = cc_common.merge_cc_infos(cc_infos = cc_infos)
+    compilation_context = cc_info.compilation_context
+    cppflags = ["-D" + d for d in compilation_context.defines.to_list()]
+    for include in compilation_context.includes.to_list():
+        cppflags.extend(["-I", include])
+    for include in compilation_context.quote_includes.to_list():
+        cppflags.extend(["-iquote", include])
+    for include in compilation_context.system_includes.to_list():
+        cppflags.extend(["-isystem", include])
+
+    dep_ldflags = []
+    libs = []
+    for linker_input in cc_info.linking_context.linker_inputs.to_list():
+        for lib in linker_input.libraries:
+            lib_file = (lib.static_library or
+                        lib.pic_static_library or
+                        lib.interface_library or
+                        lib.dynamic_library)
+            if lib_file:
+                libs.append(lib_file)
+                dep_ldflags.append(lib_file.path)
+        dep_ldflags.extend(linker_input.user_link_flags)
+
+    return struct(
+        cc = cc,
+        cppflags = cppflags,
+        cflags = cflags,
+        cxxflags = cxxflags,
+        ldflags = dep_ldflags + ldflags,
+        inputs = depset(
+            libs,
+            transitive = [cc_toolchain.all_files, compilation_context.headers],
+        ),
+    )
+
+def _format_embedsrcs(ctx, embedsrcs):
+    """Formats files that may be embedded as -embedsrc arguments.
+
+    //go:embed patterns are matched against paths relative to the package
+    directory, so each file must be in the package directory or a
+    subdirectory. Generated files are located by their short paths, which
+    don't include the output directory.
+
+    Args:
+        ctx: analysis context.
+        embedsrcs: list of Files.
+    Returns:
+        A list of strings formatted as relpath=path.
+    """
+    pkg_dir = ctx.label.package
+    if ctx.label.repo_name:
+        pkg_dir = paths.join("..", ctx.label.repo_name, pkg_dir)
+    prefix = pkg_dir + "/" if pkg_dir else ""
+    args = []
+    for f in embedsrcs:
+        if not f.short_path.startswith(prefix):
+            fail("{}: embedsrcs file {} is not in the package directory {}".format(ctx.label, f.short_path, pkg_dir))
+        args.append("{}={}".format(f.short_path[len(prefix):], f.path))
+    return args
+
+def _format_label(label):
+    """Formats a Label so it can be pasted into a BUILD file.
+
+    With bzlmod, workspace_name is the canonical name of the label's repo,
+    not the apparent name used in BUILD files, so labels in other repos are
+    written with "@@", which works in any repo.
+    """
+    if label.workspace_name:
+        return "@@{}//{}:{}".format(label.workspace_name, label.package, label.name)
+    return "//{}:{}".format(label.package, label.name)
+
+def _format_deplabel(lib):
+    """Formats a GoLibraryInfo.info object as a -deplabel argument"""
+    return "{}={}".format(lib.importpath, _format_label(lib.label))
+
 def _format_arc(lib):
-    """Formats a GoLibraryInfo.info object as an -arc argument"""
-    return "{}={}".format(lib.importpath, lib.archive.path)
+    """Formats a GoLibraryInfo.info object as an -arc argument for linking"""
+    return _format_arc_file(lib, lib.archive)
+
+def _format_export_arc(lib):
+    """Formats a GoLibraryInfo.info object as an -arc argument for compiling"""
+    return _format_arc_file(lib, lib.export)
+
+def _format_arc_file(lib, file):
+    """Formats an -arc argument mapping a library's paths to file"""
+    if lib.importmap != lib.importpath:
+        return "{}={}={}".format(lib.importpath, lib.importmap, file.path)
+    return "{}={}".format(lib.importpath, file.path)
@@ -0,0 +1,215 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// asmConfig contains settings for the Go assembler that are shared by
+// all assembly files in a package.
+type asmConfig struct {
+	packagePath string
+	goos        string
+	goarch      string
+
+	// workDir is a directory where go_asm.h, symabis, and object files
+	// are written.
+	workDir string
+}
+
+// asmhdrPath returns the path to the header file the compiler writes with
+// -asmhdr. Assembly files may include it as "go_asm.h".
+func (c asmConfig) asmhdrPath() string {
+	return filepath.Join(c.workDir, "go_asm.h")
+}
+
+// args returns arguments for the assembler common to all invocations.
+func (c asmConfig) args() ([]string, error) {
+	goroot, ok := os.LookupEnv("GOROOT")
+	if !ok {
+		return nil, fmt.Errorf("GOROOT not set")
+	}
+	trimpath, err := trimpathArg(c.workDir)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
+		"-p", c.packagePath,
+		"-trimpath", trimpath,
+		"-I", c.workDir,
+		"-I", filepath.Join(goroot, "pkg", "include"),
+		"-D", "GOOS_" + c.goos,
+		"-D", "GOARCH_" + c.goarch,
+	}
+	args = append(args, archFeatureDefines(c.goarch)...)
+	return args, nil
+}
+
+// archFeatureDefines returns -D arguments for the assembler describing the
+// architecture features enabled by variables like GOAMD64 and GOARM64. These
+// match what the go command defines, so assembly files in the standard
+// library and elsewhere can check for them with #ifdef. Variables that aren't
+// set have the same defaults as in the go command.
+func archFeatureDefines(goarch string) []string {
+	switch goarch {
+	case "386":
+		return []string{"-D", "GO386_" + envOr("GO386", "sse2")}
+
+	case "amd64":
+		return []string{"-D", "GOAMD64_" + envOr("GOAMD64", "v1")}
+
+	case "arm":
+		// GOARM may be a version like "6" or a version and a floating point
+		// mode like "7,hardfloat". Each version is a superset of the last.
+		goarm := envOr("GOARM", "7")
+		var defines []string
+		switch {
+		case strings.Contains(goarm, "7"):
+			defines = append(defines, "-D", "GOARM_7")
+			fallthrough
+		case strings.Contains(goarm, "6"):
+			defines = append(defines, "-D", "GOARM_6")
+			fallthrough
+		default:
+			defines = append(defines, "-D", "GOARM_5")
+		}
+		return defines
+
+	case "arm64":
+		if goarm64HasLSE(envOr("GOARM64", "v8.0")) {
+			return []string{"-D", "GOARM64_LSE"}
+		}
+
+	case "mips", "mipsle":
+		return []string{"-D", "GOMIPS_" + envOr("GOMIPS", "hardfloat")}
+
+	case "mips64", "mips64le":
+		return []string{"-D", "GOMIPS64_" + envOr("GOMIPS64", "hardfloat")}
+
+	case "ppc64", "ppc64le":
+		// Each POWER version is a superset of the last.
+		var defines []string
+		switch envOr("GOPPC64", "power8") {
+		case "power10":
+			defines = append(defines, "-D", "GOPPC64_power10")
+			fallthrough
+		case "power9":
+			defines = append(defines, "-D", "GOPPC64_power9")
+			fallthrough
+		default:
+			defines = append(defines, "-D", "GOPPC64_power8")
+		}
+		return defines
+
+	case "riscv64":
+		return []string{"-D", "GORISCV64_" + envOr("GORISCV64", "rva20u64")}
+	}
+	return nil
+}
+
+// goarm64HasLSE reports whether a GOARM64 value like "v8.1" or "v8.0,lse"
+// enables large system extensions (atomic instructions). They're required
+// starting with v8.1.
+func goarm64HasLSE(goarm64 string) bool {
+	version, opts, _ := strings.Cut(goarm64, ",")
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == "lse" {
+			return true
+		}
+	}
+	return strings.HasPrefix(version, "v9.") || (strings.HasPrefix(version, "v8.") && version != "v8.0")
+}
+
+// envOr returns the value of the environment variable key, or def if it's
+// not set or empty.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// runSymabis scans assembly files for symbol definitions and references
+// and writes their ABIs to a file. The compiler reads that file with
+// -symabis so that Go code can call functions implemented in assembly.
+func runSymabis(r *request, cfg asmConfig, srcPaths []string) (symabisPath string, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("generating symabis: %w", err)
+		}
+	}()
+
+	// Assembly files usually include go_asm.h, which the compiler hasn't
+	// written yet. -gensymabis parsing is lax enough that an empty file works.
+	if err := os.WriteFile(cfg.asmhdrPath(), nil, 0666); err != nil {
+		return "", err
+	}
+
+	asmTool, err := r.toolPath("asm")
+	if err != nil {
+		return "", err
+	}
+	args, err := cfg.args()
+	if err != nil {
+		return "", err
+	}
+	symabisPath = filepath.Join(cfg.workDir, "symabis")
+	args = append(args, "-gensymabis", "-o", symabisPath, "--")
+	args = append(args, srcPaths...)
+	if err := r.runCommand(nil, asmTool, args...); err != nil {
+		return "", err
+	}
+	return symabisPath, nil
+}
+
+// runAsm assembles each assembly file into an object file and returns
+// a list of the object files. It should be called after the compiler
+// has written go_asm.h.
+func runAsm(r *request, cfg asmConfig, srcPaths []string) ([]string, error) {
+	asmTool, err := r.toolPath("asm")
+	if err != nil {
+		return nil, err
+	}
+	baseArgs, err := cfg.args()
+	if err != nil {
+		return nil, err
+	}
+	objPaths := make([]string, 0, len(srcPaths))
+	for i, srcPath := range srcPaths {
+		// Object files are numbered in case files in different directories
+		// have the same base name.
+		base := strings.TrimSuffix(filepath.Base(srcPath), filepath.Ext(srcPath))
+		objPath := filepath.Join(cfg.workDir, fmt.Sprintf("%s_%d.o", base, i))
+		args := append(baseArgs[:len(baseArgs):len(baseArgs)], "-o", objPath, "--", srcPath)
+		if err := r.runCommand(nil, asmTool, args...); err != nil {
+			return nil, fmt.Errorf("assembling %s: %w", srcPath, err)
+		}
+		objPaths = append(objPaths, objPath)
+	}
+	return objPaths, nil
+}
+
+// isGoAssembly reports whether an assembly file appears to be written for
+// the Go assembler rather than the C compiler. This uses the same heuristic
+// as the go command: Go assembly has TEXT, DATA, or GLOBL directives at the
+// beginning of a line.
+func isGoAssembly(srcPath string) (bool, error) {
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return false, err
+	}
+	for _, directive := range []string{"TEXT", "DATA", "GLOBL"} {
+		if bytes.HasPrefix(data, []byte(directive)) || bytes.Contains(data, []byte("\n"+directive)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
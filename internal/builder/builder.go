@@ -6,23 +6,50 @@
 // builder is a tool used to perform various tasks related to building Go code,
 // such as compiling packages, linking executables, and generating
 // test sources.
+//
+// builder may also run as a Bazel persistent worker. In that mode, it
+// handles many requests in one process, and it caches information about
+// the standard library and source files between requests.
 package main
 
 import (
 	"fmt"
 	"os"
+	"slices"
 )
 
 func main() {
-	if len(os.Args) <= 2 {
-		fmt.Fprintf(os.Stderr, "usage: %s stdimportcfg|compile|link|test options...\n", os.Args[0])
+	args := os.Args[1:]
+	if slices.Contains(args, "--persistent_worker") {
+		if err := runWorker(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "worker: error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	args, err := expandParamsFile(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
-	verb := os.Args[1]
-	args := os.Args[2:]
+	os.Exit(run(newRequest(), args))
+}
+
+// run performs the action described by args, a verb followed by its
+// options. run reports errors to r.stderr and returns an exit code.
+func run(r *request, args []string) int {
+	if len(args) <= 1 {
+		fmt.Fprintf(r.stderr, "usage: %s stdimportcfg|compile|link|test options...\n", os.Args[0])
+		return 1
+	}
+	verb := args[0]
+	args = args[1:]
 
-	var action func(args []string) error
+	var action func(r *request, args []string) error
 	switch verb {
+	case "stdimportcfg":
+		action = stdImportcfg
 	case "compile":
 		action = compile
 	case "link":
@@ -30,13 +57,14 @@ func main() {
 	case "test":
 		action = test
 	default:
-		fmt.Fprintf(os.Stderr, "unknown action: %s\n", verb)
-		os.Exit(1)
+		fmt.Fprintf(r.stderr, "unknown action: %s\n", verb)
+		return 1
 	}
 
-	err := action(args)
+	err := action(r, args)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s: error: %v\n", verb, err)
-		os.Exit(1)
+		fmt.Fprintf(r.stderr, "%s: error: %v\n", verb, err)
+		return 1
 	}
+	return 0
 }
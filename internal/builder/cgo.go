@@ -0,0 +1,183 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// cgoConfig describes the C/C++ toolchain used to build packages that
+// import "C". It's empty when cgo is disabled.
+type cgoConfig struct {
+	cc                                  string
+	cppFlags, cFlags, cxxFlags, ldFlags []string
+}
+
+func (c cgoConfig) enabled() bool {
+	return c.cc != ""
+}
+
+// ccEnv returns environment variables for commands that may invoke the
+// C compiler. cgo reads the compiler path from the CC environment variable.
+func (c cgoConfig) ccEnv() []string {
+	return []string{"CC=" + c.cc}
+}
+
+// cgoSrcs lists the files in a package that need cgo processing.
+type cgoSrcs struct {
+	packageName                        string
+	goSrcPaths, cSrcPaths, cxxSrcPaths []string
+	hSrcPaths                          []string
+}
+
+// runCgo translates Go files that import "C" using the cgo tool, then
+// compiles the generated C files and the package's own C and C++ files
+// into object files.
+//
+// runCgo returns a list of generated Go files that should be compiled in
+// place of srcs.goSrcPaths and a list of object files that should be packed
+// into the package archive. All outputs are written to workDir.
+func runCgo(r *request, cfg cgoConfig, packagePath string, srcs cgoSrcs, workDir string) (goPaths, objPaths []string, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("running cgo: %w", err)
+		}
+	}()
+
+	// Headers may be included with paths relative to the package directory
+	// or to the directory containing cgo's generated files.
+	includeFlags := []string{"-I", workDir}
+	seenDirs := make(map[string]bool)
+	for _, paths := range [][]string{srcs.goSrcPaths, srcs.cSrcPaths, srcs.cxxSrcPaths, srcs.hSrcPaths} {
+		for _, path := range paths {
+			dir := filepath.Dir(path)
+			if !seenDirs[dir] {
+				seenDirs[dir] = true
+				includeFlags = append(includeFlags, "-I", dir)
+			}
+		}
+	}
+	// Keep the temporary directory and the execroot out of debug info.
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, nil, err
+	}
+	prefixMapFlags := []string{
+		"-fdebug-prefix-map=" + workDir + "=/tmp/go-build",
+		"-fdebug-prefix-map=" + cwd + "=.",
+	}
+	cFlags := slices.Concat(cfg.cppFlags, includeFlags, prefixMapFlags, cfg.cFlags)
+	cxxFlags := slices.Concat(cfg.cppFlags, includeFlags, prefixMapFlags, cfg.cxxFlags)
+
+	cgoTool, err := r.toolPath("cgo")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Generate Go and C files from Go files that import "C".
+	trimpath, err := trimpathArg(workDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	cgoArgs := []string{"-objdir", workDir, "-importpath", packagePath, "-trimpath", trimpath, "--"}
+	cgoArgs = append(cgoArgs, cFlags...)
+	cgoArgs = append(cgoArgs, srcs.goSrcPaths...)
+	if err := r.runCommand(cfg.ccEnv(), cgoTool, cgoArgs...); err != nil {
+		return nil, nil, err
+	}
+	genCSrcPaths := []string{filepath.Join(workDir, "_cgo_export.c")}
+	for _, srcPath := range srcs.goSrcPaths {
+		base := strings.TrimSuffix(filepath.Base(srcPath), ".go")
+		goPaths = append(goPaths, filepath.Join(workDir, base+".cgo1.go"))
+		genCSrcPaths = append(genCSrcPaths, filepath.Join(workDir, base+".cgo2.c"))
+	}
+	goTypesPath := filepath.Join(workDir, "_cgo_gotypes.go")
+	goPaths = append(goPaths, goTypesPath)
+
+	// Compile generated and hand-written C and C++ files.
+	compileC := func(srcPath string, flags []string) error {
+		objPath := filepath.Join(workDir, fmt.Sprintf("_x%03d.o", len(objPaths)))
+		args := slices.Concat(flags, []string{"-c", srcPath, "-o", objPath})
+		if err := r.runCommand(nil, cfg.cc, args...); err != nil {
+			return err
+		}
+		objPaths = append(objPaths, objPath)
+		return nil
+	}
+	for _, srcPath := range slices.Concat(genCSrcPaths, srcs.cSrcPaths) {
+		if err := compileC(srcPath, cFlags); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, srcPath := range srcs.cxxSrcPaths {
+		if err := compileC(srcPath, cxxFlags); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Link the objects into a throwaway executable and ask cgo which symbols
+	// it imports dynamically. The linker needs this for internal linking.
+	// If this link fails, we record that the package requires external
+	// linking instead, the same way the go command does.
+	mainObjPath := filepath.Join(workDir, "_cgo_main.o")
+	if err := r.runCommand(nil, cfg.cc, slices.Concat(cFlags, []string{"-c", filepath.Join(workDir, "_cgo_main.c"), "-o", mainObjPath})...); err != nil {
+		return nil, nil, err
+	}
+	cgoLdFlags, err := readCgoLdFlags(goTypesPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	dynObjPath := filepath.Join(workDir, "_cgo_.o")
+	dynLinkArgs := slices.Concat([]string{"-o", dynObjPath, mainObjPath}, objPaths, cfg.ldFlags, cgoLdFlags)
+	if err := r.runCommand(nil, cfg.cc, dynLinkArgs...); err != nil {
+		failPath := filepath.Join(workDir, "dynimportfail")
+		if err := os.WriteFile(failPath, nil, 0666); err != nil {
+			return nil, nil, err
+		}
+		objPaths = append(objPaths, failPath)
+		return goPaths, objPaths, nil
+	}
+	importPath := filepath.Join(workDir, "_cgo_import.go")
+	dynArgs := []string{"-dynpackage", srcs.packageName, "-dynimport", dynObjPath, "-dynout", importPath}
+	if err := r.runCommand(cfg.ccEnv(), cgoTool, dynArgs...); err != nil {
+		return nil, nil, err
+	}
+	goPaths = append(goPaths, importPath)
+
+	return goPaths, objPaths, nil
+}
+
+// readCgoLdFlags returns linker flags from #cgo LDFLAGS directives. cgo
+// records these as //go:cgo_ldflag directives in _cgo_gotypes.go.
+func readCgoLdFlags(goTypesPath string) ([]string, error) {
+	f, err := os.Open(goTypesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var flags []string
+	const prefix = "//go:cgo_ldflag "
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		flag, err := strconv.Unquote(strings.TrimPrefix(line, prefix))
+		if err != nil {
+			return nil, fmt.Errorf("%s: malformed directive: %s", goTypesPath, line)
+		}
+		flags = append(flags, flag)
+	}
+	return flags, scanner.Err()
+}
@@ -6,60 +6,197 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"flag"
 	"fmt"
-	"go/build"
+	"io"
+	"maps"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 )
 
-// compile produces a Go archive file (.a) from a list of .go sources.  This
+// compile produces a Go archive file (.a) from a list of sources. This
 // function filters sources using build constraints (OS and architecture
 // file name suffixes and +build comments) and builds an importcfg file
-// before invoking the Go compiler.
-func compile(args []string) error {
+// before invoking the Go compiler. Go assembly files and, if cgo is enabled,
+// C and C++ sources are compiled and packed into the archive together with
+// the Go code.
+//
+// If -exportout is set, the compiler writes the package's export data to a
+// separate archive, and the archive written to -o only contains code for the
+// linker. Packages that import this one are compiled against the export data,
+// which doesn't change when only function bodies change, so they don't need
+// to be recompiled.
+func compile(r *request, args []string) error {
 	// Process command line arguments.
-	var stdlibPath, packagePath, outPath string
+	var stdImportcfgPath, goos, goarch, label, packagePath, outPath, exportPath, goVersion, gomodPath, coverMode, pgoProfilePath string
+	var unusedDepsMode, unusedDepsPath, unusedInputsPath string
 	var archives []archive
+	var depLabels map[string]string
+	var embedSrcs []embedSrc
+	var tags, gcFlags []string
+	var instr instrumentation
+	var cgo cgoConfig
+	var nogo nogoConfig
 	fs := flag.NewFlagSet("compile", flag.ContinueOnError)
-	fs.StringVar(&stdlibPath, "stdlib", "", "path to a directory containing compiled standard library packages")
-	fs.Var(archiveFlag{&archives}, "arc", "information about dependencies, formatted as packagepath=file (may be repeated)")
-	fs.StringVar(&packagePath, "p", "", "package path for the package being compiled")
+	fs.SetOutput(r.stderr)
+	r.registerToolFlags(fs)
+	fs.StringVar(&stdImportcfgPath, "stdimportcfg", "", "path to an importcfg file listing compiled standard library packages, written by stdimportcfg")
+	fs.StringVar(&goos, "goos", "", "operating system to compile for; defaults to the host's")
+	fs.StringVar(&goarch, "goarch", "", "architecture to compile for; defaults to the host's")
+	fs.StringVar(&label, "label", "", "Bazel label of the target being built, used in error messages")
+	fs.Var(archiveFlag{&archives}, "arc", "information about direct dependencies, formatted as importpath=file or importpath=packagepath=file (may be repeated)")
+	fs.Var(stringMapFlag{&depLabels}, "deplabel", "import path and Bazel label of a direct or indirect dependency, formatted as importpath=label, used in diagnostics (may be repeated)")
+	fs.StringVar(&unusedDepsMode, "unuseddeps", "off", "how to report direct dependencies not imported by any source: off, warn, or error")
+	fs.StringVar(&unusedDepsPath, "unuseddepsout", "", "if set, path to a JSON file listing direct dependencies not imported by any source")
+	fs.StringVar(&unusedInputsPath, "unusedinputsout", "", "if set, path to a file listing dependency archives the compiler didn't read")
+	fs.StringVar(&packagePath, "p", "", "package path for the package being compiled; the importmap if the package has one")
 	fs.StringVar(&outPath, "o", "", "path to archive file the compiler should produce")
+	fs.StringVar(&exportPath, "exportout", "", "if set, path to an archive file containing only export data; the -o archive then contains only code for the linker")
+	fs.Var(embedSrcFlag{&embedSrcs}, "embedsrc", "file that may be embedded, formatted as relpath=file, where relpath is relative to the package directory (may be repeated)")
+	fs.Var(tagsFlag{&tags}, "tags", "comma-separated list of build tags to satisfy when filtering sources (may be repeated)")
+	fs.StringVar(&goVersion, "lang", "", "Go language version to compile for, like 1.21")
+	fs.StringVar(&gomodPath, "gomod", "", "path to a go.mod file whose go directive sets the language version if -lang is not set")
+	fs.Var(stringListFlag{&gcFlags}, "goopt", "flag passed to the Go compiler (may be repeated)")
+	fs.StringVar(&pgoProfilePath, "pgoprofile", "", "path to a CPU profile used for profile-guided optimization")
+	fs.StringVar(&coverMode, "covermode", "", "if set, instrument Go sources for coverage with this mode: set, count, or atomic")
+	instr.registerFlags(fs)
+	nogo.registerFlags(fs)
+	fs.StringVar(&cgo.cc, "cc", "", "path to the C/C++ compiler; enables cgo when set")
+	fs.Var(stringListFlag{&cgo.cppFlags}, "cppflag", "flag passed to the C/C++ compiler for C and C++ sources (may be repeated)")
+	fs.Var(stringListFlag{&cgo.cFlags}, "cflag", "flag passed to the C/C++ compiler for C sources (may be repeated)")
+	fs.Var(stringListFlag{&cgo.cxxFlags}, "cxxflag", "flag passed to the C/C++ compiler for C++ sources (may be repeated)")
+	fs.Var(stringListFlag{&cgo.ldFlags}, "ldflag", "flag passed to the C/C++ compiler when linking (may be repeated)")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 	srcPaths := fs.Args()
+	r.setTargetEnv(goos, goarch)
+	lang, err := langVersion(goVersion, gomodPath)
+	if err != nil {
+		return err
+	}
+	if err := checkToolFlags("goopt", gcFlags, compilerReservedFlags); err != nil {
+		return err
+	}
+	if err := checkCoverMode(coverMode); err != nil {
+		return err
+	}
+	if err := checkUnusedDepsMode(unusedDepsMode); err != nil {
+		return err
+	}
+	if err := instr.check(); err != nil {
+		return err
+	}
+	tags = append(tags, instr.tags()...)
+	if cFlags := instr.cFlags(); len(cFlags) > 0 {
+		cgo.cFlags = append(cgo.cFlags, cFlags...)
+		cgo.cxxFlags = append(cgo.cxxFlags, cFlags...)
+		cgo.ldFlags = append(cgo.ldFlags, cFlags...)
+	}
 
 	// Extract metadata from source files and filter out sources using
-	// build constraints.
-	srcs := make([]sourceInfo, 0, len(srcPaths))
-	filteredSrcPaths := make([]string, 0, len(srcPaths))
-	bctx := &build.Default
+	// build constraints. Go sources that import "C" are processed by cgo.
+	var srcs []sourceInfo
+	var goSrcPaths, asmSrcPaths []string
+	cSrcs := cgoSrcs{}
+	bctx := newBuildContext(goos, goarch, tags)
+	bctx.CgoEnabled = cgo.enabled()
 	var errs []error
 	for _, srcPath := range srcPaths {
-		src, err := loadSourceInfo(bctx, srcPath)
+		src, err := loadSourceInfo(r, bctx, srcPath)
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
-		if src.match {
+		if !src.match {
+			continue
+		}
+		switch filepath.Ext(srcPath) {
+		case ".go":
 			srcs = append(srcs, src)
-			filteredSrcPaths = append(filteredSrcPaths, srcPath)
+			if slices.Contains(src.imports, "C") {
+				cSrcs.goSrcPaths = append(cSrcs.goSrcPaths, srcPath)
+				cSrcs.packageName = src.packageName
+			} else {
+				goSrcPaths = append(goSrcPaths, srcPath)
+			}
+		case ".s", ".S", ".sx":
+			asmSrcPaths = append(asmSrcPaths, srcPath)
+		case ".c":
+			cSrcs.cSrcPaths = append(cSrcs.cSrcPaths, srcPath)
+		case ".cc", ".cpp", ".cxx":
+			cSrcs.cxxSrcPaths = append(cSrcs.cxxSrcPaths, srcPath)
+		case ".h", ".hh", ".hpp", ".hxx":
+			cSrcs.hSrcPaths = append(cSrcs.hSrcPaths, srcPath)
+		default:
+			errs = append(errs, fmt.Errorf("%s: unsupported source file extension", srcPath))
 		}
 	}
+	useCgo := len(cSrcs.goSrcPaths) > 0
+	if !useCgo && len(cSrcs.cSrcPaths)+len(cSrcs.cxxSrcPaths) > 0 {
+		errs = append(errs, errors.New(`C and C++ sources are not allowed unless a Go source imports "C"`))
+	}
+
+	// Like the go command, assemble .s, .S, and .sx files with the Go
+	// assembler, unless the package uses cgo. Then they're assembled by the
+	// C compiler, and Go assembly isn't allowed.
+	if useCgo {
+		for _, srcPath := range asmSrcPaths {
+			if isGoAsm, err := isGoAssembly(srcPath); err != nil {
+				errs = append(errs, err)
+			} else if isGoAsm {
+				errs = append(errs, fmt.Errorf("package using cgo has Go assembly file %s", srcPath))
+			}
+		}
+		cSrcs.cSrcPaths = append(cSrcs.cSrcPaths, asmSrcPaths...)
+		asmSrcPaths = nil
+	}
+
+	// Go sources that don't import "C" are instrumented for coverage.
+	// Instrumented files may import additional packages.
+	cover := coverConfig{
+		mode:        coverMode,
+		packagePath: packagePath,
+	}
+	if len(srcs) > 0 {
+		cover.packageName = srcs[0].packageName
+	}
+	useCover := coverMode != "" && len(goSrcPaths) > 0
 
 	// Build an importcfg file that maps this package's imports to archive files
-	// from the standard library or direct dependencies.
-	directArchiveMap := make(map[string]string)
+	// from the standard library or direct dependencies. A direct dependency
+	// may have a package path that differs from its import path.
+	directArchiveMap := make(map[string]archive)
 	for _, arc := range archives {
-		directArchiveMap[arc.packagePath] = arc.filePath
+		if prev, ok := directArchiveMap[arc.importPath]; ok && prev.packagePath != arc.packagePath {
+			errs = append(errs, fmt.Errorf("multiple direct dependencies have import path %q: %s and %s", arc.importPath, prev.packagePath, arc.packagePath))
+			continue
+		}
+		directArchiveMap[arc.importPath] = arc
 	}
 
+	stdArchiveMap, err := readStdImportcfg(r, stdImportcfgPath)
+	if err != nil {
+		return err
+	}
+	importMap := make(map[string]string)
 	archiveMap := make(map[string]string)
+	standard := make(map[string]bool)
+	usedDeps := make(map[string]bool)
 	for _, src := range srcs {
-		for _, imp := range src.imports {
+		imports := src.imports
+		if useCgo {
+			// Code generated by cgo imports these packages.
+			imports = slices.Concat(imports, []string{"runtime/cgo", "syscall"})
+		}
+		if useCover {
+			imports = slices.Concat(imports, cover.imports())
+		}
+		for _, imp := range imports {
 			if _, ok := archiveMap[imp]; ok {
 				// Already added.
 				continue
@@ -69,47 +206,301 @@ func compile(args []string) error {
 				continue
 			}
 			if imp == "C" {
-				errs = append(errs, fmt.Errorf("%s: cgo not supported", src.fileName))
+				if !cgo.enabled() {
+					errs = append(errs, fmt.Errorf("%s: cgo is not enabled for this package", src.fileName))
+				}
+				continue
 			}
-			if path, ok := directArchiveMap[imp]; ok {
-				archiveMap[imp] = path
+			if arc, ok := directArchiveMap[imp]; ok {
+				usedDeps[imp] = true
+				if arc.packagePath != imp {
+					importMap[imp] = arc.packagePath
+				}
+				archiveMap[arc.packagePath] = arc.filePath
 				continue
 			}
-			if stdPath, ok := isStdPackage(stdlibPath, imp); ok {
+			if stdPath, ok := stdArchiveMap[imp]; ok {
 				archiveMap[imp] = stdPath
+				standard[imp] = true
 				continue
 			}
-			errs = append(errs, fmt.Errorf("%s: import %q is not provided by any direct dependency", src.fileName, imp))
+			errs = append(errs, missingDepError(src.fileName, imp, depLabels, label))
 		}
 	}
 	if err := errors.Join(errs...); err != nil {
 		return err
 	}
+	unused := findUnusedDeps(archives, usedDeps, depLabels)
+	if err := reportUnusedDeps(r, unusedDepsMode, unusedDepsPath, label, unused); err != nil {
+		return err
+	}
+
+	// The compiler only reads archives listed in the importcfg file, so any
+	// other dependency or standard library archive may change without
+	// affecting the output.
+	if unusedInputsPath != "" {
+		inputArchiveMap := maps.Clone(stdArchiveMap)
+		for _, arc := range archives {
+			inputArchiveMap[arc.packagePath] = arc.filePath
+		}
+		used := make(map[string]bool)
+		for _, path := range archiveMap {
+			used[path] = true
+		}
+		if err := writeUnusedInputs(unusedInputsPath, inputArchiveMap, used); err != nil {
+			return err
+		}
+	}
 
-	importcfgPath, err := writeTempImportcfg(archiveMap)
+	importcfgPath, err := writeTempImportcfg(importMap, archiveMap)
 	if err != nil {
 		return err
 	}
 	defer os.Remove(importcfgPath)
 
+	// Build an embedcfg file that maps //go:embed patterns to files.
+	opts := compilerOptions{exportPath: exportPath, lang: lang, pgoProfilePath: pgoProfilePath, instr: instr, gcFlags: gcFlags}
+	opts.embedcfgPath, err = writeTempEmbedcfg(srcs, embedSrcs)
+	if err != nil {
+		return err
+	}
+	if opts.embedcfgPath != "" {
+		defer os.Remove(opts.embedcfgPath)
+	}
+
+	// Create a directory for intermediate files if we need to compile
+	// anything other than Go code.
+	var workDir string
+	if useCgo || useCover || len(asmSrcPaths) > 0 {
+		workDir, err = os.MkdirTemp("", "compile-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(workDir)
+		opts.workDir = workDir
+	}
+
+	// Static analysis sees Go sources before they're instrumented for
+	// coverage, together with files generated by cgo.
+	nogoSrcPaths := slices.Clone(goSrcPaths)
+
+	// Instrument Go sources for coverage if needed.
+	if useCover {
+		cover.workDir = workDir
+		goSrcPaths, opts.coveragecfgPath, err = runCover(r, cover, goSrcPaths)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Run cgo and compile C and C++ sources if needed.
+	var objPaths []string
+	if useCgo {
+		cgoGoPaths, cgoObjPaths, err := runCgo(r, cgo, packagePath, cSrcs, workDir)
+		if err != nil {
+			return err
+		}
+		goSrcPaths = append(goSrcPaths, cgoGoPaths...)
+		nogoSrcPaths = append(nogoSrcPaths, cgoGoPaths...)
+		objPaths = cgoObjPaths
+	}
+
+	// Scan assembly files for symbols they define, so the compiler can
+	// check Go declarations against them and write go_asm.h.
+	asm := asmConfig{
+		packagePath: packagePath,
+		goos:        bctx.GOOS,
+		goarch:      bctx.GOARCH,
+		workDir:     workDir,
+	}
+	if len(asmSrcPaths) > 0 {
+		opts.symabisPath, err = runSymabis(r, asm, asmSrcPaths)
+		if err != nil {
+			return err
+		}
+		opts.asmhdrPath = asm.asmhdrPath()
+	}
+
 	// Invoke the compiler.
-	return runCompiler(packagePath, importcfgPath, filteredSrcPaths, outPath)
+	if err := runCompiler(r, packagePath, importcfgPath, goSrcPaths, outPath, opts); err != nil {
+		return err
+	}
+
+	// Run static analysis on the package. The compiler has already reported
+	// any type errors.
+	if nogo.enabled() {
+		pkg := vetPackage{
+			packagePath: packagePath,
+			srcPaths:    nogoSrcPaths,
+			lang:        lang,
+			importMap:   importMap,
+			archiveMap:  archiveMap,
+			standard:    standard,
+		}
+		if err := runNogo(r, nogo, pkg); err != nil {
+			return err
+		}
+	}
+
+	// Assemble assembly files, which may include go_asm.h.
+	if len(asmSrcPaths) > 0 {
+		asmObjPaths, err := runAsm(r, asm, asmSrcPaths)
+		if err != nil {
+			return err
+		}
+		objPaths = append(objPaths, asmObjPaths...)
+	}
+
+	// Add objects compiled from other languages to the archive.
+	if len(objPaths) > 0 {
+		return packObjects(outPath, objPaths)
+	}
+	return nil
+}
+
+// compilerOptions contains optional arguments for runCompiler.
+// Empty fields are not passed to the compiler.
+type compilerOptions struct {
+	// exportPath is set to write export data to a separate archive. The
+	// archive at outPath then contains only code for the linker.
+	exportPath string
+
+	// asmhdrPath and symabisPath are set for packages with assembly files.
+	asmhdrPath, symabisPath string
+
+	// embedcfgPath is set for packages with //go:embed directives.
+	embedcfgPath string
+
+	// coveragecfgPath is set for packages instrumented for coverage.
+	coveragecfgPath string
+
+	// workDir is a temporary directory containing generated sources. It's
+	// trimmed from file paths recorded in the archive.
+	workDir string
+
+	// lang is the Go language version, like "go1.21". Files with
+	// //go:build go1.N constraints are compiled at version go1.N or higher.
+	lang string
+
+	// pgoProfilePath is a CPU profile in pprof format. If set, the compiler
+	// uses it to guide optimizations like inlining.
+	pgoProfilePath string
+
+	// instr enables instrumentation for the race detector or a sanitizer.
+	instr instrumentation
+
+	// gcFlags are additional flags set by the user.
+	gcFlags []string
 }
 
-func runCompiler(packagePath, importcfgPath string, srcPaths []string, outPath string) error {
-	args := []string{"tool", "compile"}
+// compilerReservedFlags lists compiler flags that runCompiler sets.
+// Users may not set these with -goopt.
+var compilerReservedFlags = []string{"asan", "asmhdr", "coveragecfg", "embedcfg", "importcfg", "lang", "linkobj", "msan", "o", "p", "pack", "pgoprofile", "race", "symabis", "trimpath"}
+
+func runCompiler(r *request, packagePath, importcfgPath string, srcPaths []string, outPath string, opts compilerOptions) error {
+	var args []string
 	if packagePath != "" {
 		args = append(args, "-p", packagePath)
 	}
-	args = append(args, "-importcfg", importcfgPath)
-	args = append(args, "-o", outPath, "--")
+	trimpath, err := trimpathArg(opts.workDir)
+	if err != nil {
+		return err
+	}
+	args = append(args, "-importcfg", importcfgPath, "-trimpath", trimpath)
+	if opts.asmhdrPath != "" {
+		args = append(args, "-asmhdr", opts.asmhdrPath)
+	}
+	if opts.symabisPath != "" {
+		args = append(args, "-symabis", opts.symabisPath)
+	}
+	if opts.embedcfgPath != "" {
+		args = append(args, "-embedcfg", opts.embedcfgPath)
+	}
+	if opts.coveragecfgPath != "" {
+		args = append(args, "-coveragecfg", opts.coveragecfgPath)
+	}
+	if opts.lang != "" {
+		args = append(args, "-lang", opts.lang)
+	}
+	if opts.pgoProfilePath != "" {
+		args = append(args, "-pgoprofile", opts.pgoProfilePath)
+	}
+	args = append(args, opts.instr.toolFlags()...)
+	args = append(args, opts.gcFlags...)
+	if opts.exportPath != "" {
+		args = append(args, "-linkobj", outPath, "-o", opts.exportPath)
+	} else {
+		args = append(args, "-o", outPath)
+	}
+	args = append(args, "--")
 	args = append(args, srcPaths...)
-	goTool, err := findGoTool()
+	compileTool, err := r.toolPath("compile")
+	if err != nil {
+		return err
+	}
+	return r.runCommand(nil, compileTool, args...)
+}
+
+// packObjects appends object files to an existing archive written by the
+// compiler. This does the same thing as "go tool pack r", but the pack tool
+// isn't included in Go distributions, so the go command does it internally,
+// and so do we.
+func packObjects(archivePath string, objPaths []string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("packing objects into %s: %w", archivePath, err)
+		}
+	}()
+
+	dst, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer dst.Close() // only for error returns
+	w := bufio.NewWriter(dst)
+	for _, objPath := range objPaths {
+		if err := packObject(w, objPath); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return dst.Close()
+}
+
+// packObject writes an archive member header for the file at objPath,
+// followed by its contents.
+func packObject(w *bufio.Writer, objPath string) error {
+	src, err := os.Open(objPath)
 	if err != nil {
 		return err
 	}
-	cmd := exec.Command(goTool, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Names are truncated or padded to 16 bytes, not runes, so a format
+	// like %-16.16s doesn't work.
+	name := fi.Name()
+	if len(name) > 16 {
+		name = name[:16]
+	} else {
+		name += strings.Repeat(" ", 16-len(name))
+	}
+	size := fi.Size()
+	fmt.Fprintf(w, "%s%-12d%-6d%-6d%-8o%-10d`\n", name, 0, 0, 0, 0644, size)
+	n, err := io.Copy(w, src)
+	if err != nil {
+		return err
+	} else if n != size {
+		return fmt.Errorf("%s: size changed while copying", objPath)
+	}
+	// Members are aligned to even offsets.
+	if size%2 != 0 {
+		return w.WriteByte(0)
+	}
+	return nil
 }
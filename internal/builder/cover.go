@@ -0,0 +1,126 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// coverConfig contains settings for instrumenting a package for coverage.
+type coverConfig struct {
+	// mode is the counter mode: "set", "count", or "atomic".
+	mode string
+
+	packagePath, packageName string
+
+	// workDir is a directory where instrumented files and configuration
+	// files are written.
+	workDir string
+}
+
+// imports returns packages that instrumented files import, in addition to
+// the imports of the original files.
+func (c coverConfig) imports() []string {
+	var imports []string
+	if c.mode == "atomic" {
+		imports = append(imports, "sync/atomic")
+	}
+	if c.packageName == "main" {
+		imports = append(imports, "runtime/coverage")
+	}
+	return imports
+}
+
+// runCover instruments Go source files for coverage using the cover tool.
+// runCover returns a list of instrumented files, which should be compiled
+// instead of srcPaths, and a path to a configuration file, which should be
+// passed to the compiler with -coveragecfg.
+//
+// Coverage data refers to each file by the path it was passed in with, so
+// srcPaths should be relative to the execroot. That's what Bazel expects
+// in coverage reports.
+func runCover(r *request, cfg coverConfig, srcPaths []string) (coverPaths []string, coveragecfgPath string, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("instrumenting for coverage: %w", err)
+		}
+	}()
+
+	// Write a configuration file for the cover tool. This is normally
+	// written by the go command. Local mode tells the tool to record file
+	// paths as they are instead of as packagepath/basename.
+	coveragecfgPath = filepath.Join(cfg.workDir, "coveragecfg")
+	pkgcfg := struct {
+		OutConfig, PkgPath, PkgName, Granularity string
+		Local                                    bool
+	}{
+		OutConfig:   coveragecfgPath,
+		PkgPath:     cfg.packagePath,
+		PkgName:     cfg.packageName,
+		Granularity: "perblock",
+		Local:       true,
+	}
+	pkgcfgData, err := json.Marshal(pkgcfg)
+	if err != nil {
+		return nil, "", err
+	}
+	pkgcfgPath := filepath.Join(cfg.workDir, "pkgcfg.json")
+	if err := os.WriteFile(pkgcfgPath, pkgcfgData, 0666); err != nil {
+		return nil, "", err
+	}
+
+	// The cover tool writes a file declaring counter variables, then one
+	// instrumented file for each source file. Output files are numbered in
+	// case files in different directories have the same base name.
+	coverPaths = []string{filepath.Join(cfg.workDir, "covervars.go")}
+	for i, srcPath := range srcPaths {
+		base := strings.TrimSuffix(filepath.Base(srcPath), ".go")
+		coverPaths = append(coverPaths, filepath.Join(cfg.workDir, fmt.Sprintf("%s_%d.cover.go", base, i)))
+	}
+	outFileListPath := filepath.Join(cfg.workDir, "coveroutfiles.txt")
+	outFileList := strings.Join(coverPaths, "\n") + "\n"
+	if err := os.WriteFile(outFileListPath, []byte(outFileList), 0666); err != nil {
+		return nil, "", err
+	}
+
+	coverTool, err := r.toolPath("cover")
+	if err != nil {
+		return nil, "", err
+	}
+	args := []string{
+		"-pkgcfg", pkgcfgPath,
+		"-mode", cfg.mode,
+		"-var", coverVarPrefix(cfg.packagePath),
+		"-outfilelist", outFileListPath,
+	}
+	args = append(args, srcPaths...)
+	if err := r.runCommand(nil, coverTool, args...); err != nil {
+		return nil, "", err
+	}
+	return coverPaths, coveragecfgPath, nil
+}
+
+// coverVarPrefix returns a prefix for the names of counter variables in an
+// instrumented package. The names must not collide with anything declared
+// in the package, so like the go command, we include a hash.
+func coverVarPrefix(packagePath string) string {
+	sum := sha256.Sum256([]byte(packagePath))
+	return fmt.Sprintf("goCover_%x_", sum[:6])
+}
+
+// checkCoverMode returns an error if mode is not empty and not a valid
+// coverage counter mode.
+func checkCoverMode(mode string) error {
+	if mode != "" && mode != "set" && mode != "count" && mode != "atomic" {
+		return fmt.Errorf("invalid coverage mode %q: must be set, count, or atomic", mode)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// embedSrc is a file that may be embedded into a package with //go:embed.
+type embedSrc struct {
+	// relPath is the slash-separated path of the file relative to the
+	// package directory. //go:embed patterns are matched against this.
+	relPath string
+
+	// filePath is the path to the file that the compiler should read.
+	filePath string
+}
+
+// embedcfg is the JSON configuration read by the compiler's -embedcfg flag.
+type embedcfg struct {
+	// Patterns maps each //go:embed pattern to the list of package-relative
+	// file paths it matches.
+	Patterns map[string][]string
+
+	// Files maps each package-relative file path to an actual file path.
+	Files map[string]string
+}
+
+// writeTempEmbedcfg matches //go:embed patterns in srcs against embedSrcs
+// and writes a configuration file for the compiler. If srcs contain no
+// patterns, writeTempEmbedcfg returns "" and no error. Otherwise, the caller
+// is responsible for deleting the file.
+func writeTempEmbedcfg(srcs []sourceInfo, embedSrcs []embedSrc) (string, error) {
+	var patterns []string
+	for _, src := range srcs {
+		patterns = append(patterns, src.embedPatterns...)
+	}
+	if len(patterns) == 0 {
+		return "", nil
+	}
+	cfg, err := buildEmbedcfg(patterns, embedSrcs)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	tmpFile, err := os.CreateTemp("", "embedcfg-*")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmpFile.Name()
+	_, err = tmpFile.Write(data)
+	if cerr := tmpFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// buildEmbedcfg matches each pattern against embedSrcs, following the rules
+// of the go command. A pattern may match files directly, or it may match
+// directories, in which case all files in each directory are embedded
+// recursively. Files in matched directories with names beginning with
+// '.' or '_' are excluded unless the pattern has the "all:" prefix.
+func buildEmbedcfg(patterns []string, embedSrcs []embedSrc) (embedcfg, error) {
+	cfg := embedcfg{
+		Patterns: make(map[string][]string),
+		Files:    make(map[string]string),
+	}
+
+	// Build a set of directories that contain embeddable files, since
+	// directories aren't listed explicitly.
+	fileMap := make(map[string]string)
+	dirSet := make(map[string]bool)
+	for _, src := range embedSrcs {
+		fileMap[src.relPath] = src.filePath
+		for dir := path.Dir(src.relPath); dir != "."; dir = path.Dir(dir) {
+			dirSet[dir] = true
+		}
+	}
+
+	var errs []error
+	for _, pattern := range patterns {
+		if _, ok := cfg.Patterns[pattern]; ok {
+			continue
+		}
+		glob, all := strings.CutPrefix(pattern, "all:")
+		if err := validateEmbedPattern(glob); err != nil {
+			errs = append(errs, fmt.Errorf("pattern %s: %w", pattern, err))
+			continue
+		}
+
+		var matched []string
+		dirErr := false
+		for relPath := range fileMap {
+			if ok, _ := path.Match(glob, relPath); ok {
+				matched = append(matched, relPath)
+			}
+		}
+		for dir := range dirSet {
+			if ok, _ := path.Match(glob, dir); !ok {
+				continue
+			}
+			n := len(matched)
+			for relPath := range fileMap {
+				if rest, ok := strings.CutPrefix(relPath, dir+"/"); ok && (all || !hasHiddenElem(rest)) {
+					matched = append(matched, relPath)
+				}
+			}
+			if len(matched) == n {
+				errs = append(errs, fmt.Errorf("pattern %s: cannot embed directory %s: contains no embeddable files", pattern, dir))
+				dirErr = true
+			}
+		}
+		if dirErr {
+			continue
+		}
+		if len(matched) == 0 {
+			errs = append(errs, fmt.Errorf("pattern %s: no matching files found", pattern))
+			continue
+		}
+
+		slices.Sort(matched)
+		matched = slices.Compact(matched)
+		cfg.Patterns[pattern] = matched
+		for _, relPath := range matched {
+			cfg.Files[relPath] = fileMap[relPath]
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return embedcfg{}, err
+	}
+	return cfg, nil
+}
+
+// validateEmbedPattern reports an error if a pattern is syntactically
+// invalid or refers to files outside the package directory.
+func validateEmbedPattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return errors.New("invalid pattern syntax")
+	}
+	if pattern == "" || strings.HasPrefix(pattern, "/") || strings.HasSuffix(pattern, "/") {
+		return errors.New("invalid pattern syntax")
+	}
+	for _, elem := range strings.Split(pattern, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return errors.New("invalid pattern syntax")
+		}
+	}
+	return nil
+}
+
+// hasHiddenElem returns whether any element of a slash-separated path
+// begins with '.' or '_'.
+func hasHiddenElem(p string) bool {
+	for _, elem := range strings.Split(p, "/") {
+		if strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEmbedDirective returns the patterns listed in a //go:embed
+// comment. ok is false if the comment is not a //go:embed directive.
+// Patterns are separated by spaces and may be quoted with double quotes
+// or back quotes.
+func parseEmbedDirective(comment string) (patterns []string, ok bool, err error) {
+	args, ok := strings.CutPrefix(comment, "//go:embed")
+	if !ok || (args != "" && args[0] != ' ' && args[0] != '\t') {
+		return nil, false, nil
+	}
+	args = strings.TrimLeftFunc(args, unicode.IsSpace)
+	for args != "" {
+		var pattern string
+		switch args[0] {
+		case '"', '`':
+			quoted, err := strconv.QuotedPrefix(args)
+			if err != nil {
+				return nil, true, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+			}
+			pattern, _ = strconv.Unquote(quoted)
+			args = args[len(quoted):]
+			if args != "" && !unicode.IsSpace(rune(args[0])) {
+				return nil, true, fmt.Errorf("invalid quoted string in //go:embed: %s", quoted+args)
+			}
+		default:
+			end := strings.IndexFunc(args, unicode.IsSpace)
+			if end < 0 {
+				end = len(args)
+			}
+			pattern, args = args[:end], args[end:]
+		}
+		patterns = append(patterns, pattern)
+		args = strings.TrimLeftFunc(args, unicode.IsSpace)
+	}
+	if len(patterns) == 0 {
+		return nil, true, errors.New("usage: //go:embed pattern...")
+	}
+	return patterns, true, nil
+}
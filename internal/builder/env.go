@@ -6,26 +6,129 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
-	"runtime"
+	"slices"
+	"strings"
 )
 
-// findGoTool finds and returns an absolute path to the Go command, based
-// on the GOROOT environment variable.
-func findGoTool() (string, error) {
-	goroot, ok := os.LookupEnv("GOROOT")
-	if !ok {
-		return "", fmt.Errorf("GOROOT not set")
+// trimpathArg returns a -trimpath argument for the compiler, assembler, and
+// cgo that makes file paths recorded in outputs independent of where the
+// build ran. Paths in dirs (typically temporary directories holding
+// generated files) and the working directory (Bazel's execroot) are
+// removed, and GOROOT is replaced with "$GOROOT".
+func trimpathArg(dirs ...string) (string, error) {
+	var rewrites []string
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return "", err
+		}
+		rewrites = append(rewrites, absDir+"=>")
+	}
+	// GOROOT is usually inside the execroot, so it must be rewritten first.
+	if goroot, ok := os.LookupEnv("GOROOT"); ok {
+		absGoroot, err := filepath.Abs(goroot)
+		if err != nil {
+			return "", err
+		}
+		rewrites = append(rewrites, absGoroot+"=>$GOROOT")
 	}
-	absGoroot, err := filepath.Abs(goroot)
+	cwd, err := os.Getwd()
 	if err != nil {
 		return "", err
 	}
-	ext := ""
-	if runtime.GOOS == "windows" {
-		ext = ".exe"
+	rewrites = append(rewrites, cwd+"=>")
+	return strings.Join(rewrites, ";"), nil
+}
+
+// request holds state for one invocation of a builder verb: either the
+// builder's command line or a work request received by a persistent worker.
+// Workers may handle several requests at once, so anything that varies
+// between requests, like the target platform and where output goes, must be
+// kept here instead of in the process's environment.
+type request struct {
+	// stdout and stderr receive output from tools the builder runs and
+	// diagnostics from the builder itself. In a worker, they're the same
+	// buffer, which is returned in the work response.
+	stdout, stderr io.Writer
+
+	// env is the environment for tools the builder runs. If nil, tools
+	// inherit the builder's environment.
+	env []string
+
+	// digests maps input file paths to digests computed by Bazel. It's only
+	// set for work requests. Data derived from an input with a digest may be
+	// cached between requests.
+	digests map[string]string
+
+	// tools maps names of tools from the Go distribution, like "compile" and
+	// "link", to paths of their executables in pkg/tool. The builder runs
+	// these directly rather than through "go tool", which would start the go
+	// command for every invocation.
+	tools map[string]string
+}
+
+// newRequest returns a request that writes output to the builder's own
+// standard output and error.
+func newRequest() *request {
+	return &request{stdout: os.Stdout, stderr: os.Stderr}
+}
+
+// registerToolFlags adds a command line flag that sets paths to tools from
+// the Go distribution.
+func (r *request) registerToolFlags(fs *flag.FlagSet) {
+	fs.Var(stringMapFlag{&r.tools}, "tool", "path to a tool from the Go distribution, formatted as name=file (may be repeated)")
+}
+
+// toolPath returns an absolute path to the named tool from the Go
+// distribution, set with -tool.
+func (r *request) toolPath(name string) (string, error) {
+	path, ok := r.tools[name]
+	if !ok {
+		return "", fmt.Errorf("path to %s tool not set with -tool", name)
+	}
+	return filepath.Abs(path)
+}
+
+// setTargetEnv sets GOOS and GOARCH in the environment of tools the builder
+// runs, so that the compiler, linker, and other tools produce code for the
+// target platform. Empty values are ignored, and the tools target the
+// platform the builder runs on.
+func (r *request) setTargetEnv(goos, goarch string) {
+	if goos == "" && goarch == "" {
+		return
+	}
+	env := os.Environ()
+	if goos != "" {
+		env = append(env, "GOOS="+goos)
+	}
+	if goarch != "" {
+		env = append(env, "GOARCH="+goarch)
+	}
+	r.env = env
+}
+
+// runCommand runs an executable with the given arguments. extraEnv is
+// added to the request's environment. The command's standard output and
+// error are forwarded to the request's.
+func (r *request) runCommand(extraEnv []string, path string, args ...string) error {
+	cmd := exec.Command(path, args...)
+	if r.env != nil || extraEnv != nil {
+		env := r.env
+		if env == nil {
+			env = os.Environ()
+		}
+		cmd.Env = slices.Concat(env, extraEnv)
 	}
-	return filepath.Join(absGoroot, "bin", "go"+ext), nil
+	cmd.Stdout = r.stdout
+	cmd.Stderr = r.stderr
+	return cmd.Run()
 }
@@ -7,17 +7,23 @@ package main
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 )
 
 // archive is a mapping from a package path (e.g., "fmt") to a file system
 // path to the package's archive (e.g., "/opt/go/pkg/linux_amd64/fmt.a").
+//
+// importPath is the path other packages use to import the package.
+// packagePath is the path the package was compiled with, which must be
+// unique in a binary. They're the same unless the package has an importmap,
+// for example, because it's a vendored copy of another package.
 type archive struct {
-	packagePath, filePath string
+	importPath, packagePath, filePath string
 }
 
 // archiveFlag parses archives from command line arguments. Archive values
-// have the form "packagePath=filePath".
+// have the form "importPath=filePath" or "importPath=packagePath=filePath".
 type archiveFlag struct {
 	archives *[]archive
 }
@@ -29,21 +35,149 @@ func (f archiveFlag) String() string {
 	b := &strings.Builder{}
 	sep := ""
 	for _, arc := range *f.archives {
-		fmt.Fprintf(b, "%s%s=%s", sep, arc.packagePath, arc.filePath)
+		if arc.importPath == arc.packagePath {
+			fmt.Fprintf(b, "%s%s=%s", sep, arc.importPath, arc.filePath)
+		} else {
+			fmt.Fprintf(b, "%s%s=%s=%s", sep, arc.importPath, arc.packagePath, arc.filePath)
+		}
 		sep = " "
 	}
 	return b.String()
 }
 
 func (f archiveFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 3)
+	var arc archive
+	switch len(parts) {
+	case 2:
+		arc = archive{importPath: parts[0], packagePath: parts[0], filePath: parts[1]}
+	case 3:
+		arc = archive{importPath: parts[0], packagePath: parts[1], filePath: parts[2]}
+	default:
+		return fmt.Errorf("malformed -arc flag: %q", value)
+	}
+	*f.archives = append(*f.archives, arc)
+	return nil
+}
+
+// embedSrcFlag parses files that may be embedded from command line arguments.
+// Values have the form "relPath=filePath", where relPath is relative to the
+// package directory.
+type embedSrcFlag struct {
+	srcs *[]embedSrc
+}
+
+func (f embedSrcFlag) String() string {
+	if f.srcs == nil {
+		return ""
+	}
+	b := &strings.Builder{}
+	sep := ""
+	for _, src := range *f.srcs {
+		fmt.Fprintf(b, "%s%s=%s", sep, src.relPath, src.filePath)
+		sep = " "
+	}
+	return b.String()
+}
+
+func (f embedSrcFlag) Set(value string) error {
 	pos := strings.IndexByte(value, '=')
 	if pos < 0 {
-		return fmt.Errorf("malformed -arc flag: %q", value)
+		return fmt.Errorf("malformed -embedsrc flag: %q", value)
 	}
-	arc := archive{
-		packagePath: value[:pos],
-		filePath:    value[pos+1:],
+	src := embedSrc{
+		relPath:  value[:pos],
+		filePath: value[pos+1:],
+	}
+	*f.srcs = append(*f.srcs, src)
+	return nil
+}
+
+// stringListFlag parses a list of strings from command line arguments.
+// Each occurrence of the flag appends one value to the list.
+type stringListFlag struct {
+	values *[]string
+}
+
+func (f stringListFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return strings.Join(*f.values, " ")
+}
+
+func (f stringListFlag) Set(value string) error {
+	*f.values = append(*f.values, value)
+	return nil
+}
+
+// stringMapFlag parses a map from command line arguments. Values have the
+// form "key=value", for example, "importPath=label" for -deplabel. If a key
+// is repeated, the last value is used.
+type stringMapFlag struct {
+	values *map[string]string
+}
+
+func (f stringMapFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	b := &strings.Builder{}
+	sep := ""
+	for _, key := range sortedKeys(*f.values) {
+		fmt.Fprintf(b, "%s%s=%s", sep, key, (*f.values)[key])
+		sep = " "
+	}
+	return b.String()
+}
+
+func (f stringMapFlag) Set(value string) error {
+	pos := strings.IndexByte(value, '=')
+	if pos < 0 {
+		return fmt.Errorf("malformed value %q: expected key=value", value)
+	}
+	if *f.values == nil {
+		*f.values = make(map[string]string)
+	}
+	(*f.values)[value[:pos]] = value[pos+1:]
+	return nil
+}
+
+// tagsFlag parses a list of build tags from command line arguments. Like the
+// go command's -tags flag, tags may be separated by commas or spaces.
+type tagsFlag struct {
+	tags *[]string
+}
+
+func (f tagsFlag) String() string {
+	if f.tags == nil {
+		return ""
+	}
+	return strings.Join(*f.tags, ",")
+}
+
+func (f tagsFlag) Set(value string) error {
+	tags := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	*f.tags = append(*f.tags, tags...)
+	return nil
+}
+
+// checkToolFlags returns an error if flags, which are passed through to a Go
+// tool like the compiler or linker, include one of the reserved flags. The
+// builder sets reserved flags itself, so they may not be overridden.
+// optName is the builder flag that flags were passed with.
+func checkToolFlags(optName string, flags, reserved []string) error {
+	for _, f := range flags {
+		if !strings.HasPrefix(f, "-") {
+			// Value of a preceding flag.
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(f, "-"), "=")
+		if slices.Contains(reserved, name) {
+			return fmt.Errorf("-%s %s: flag is set by the builder and may not be overridden", optName, f)
+		}
 	}
-	*f.archives = append(*f.archives, arc)
 	return nil
 }
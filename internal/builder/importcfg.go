@@ -9,32 +9,44 @@ import (
 	"bytes"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 )
 
-// isStdPackage returns the path to a compile package file in the standard
-// library and a bool indicating whether it exists.
-func isStdPackage(stdlibPath, imp string) (pkgFile string, exists bool) {
-	pkgFile = filepath.Join(stdlibPath, imp+".a")
-	_, err := os.Stat(pkgFile)
-	return pkgFile, err == nil
+// missingDepError returns an error for an import in fileName that isn't
+// provided by the standard library or any direct dependency. depLabels
+// maps import paths of direct and indirect dependencies to their Bazel
+// labels. If the imported package is provided by an indirect dependency,
+// the error says which target to add to the deps of label, the target being
+// built.
+func missingDepError(fileName, imp string, depLabels map[string]string, label string) error {
+	depLabel, ok := depLabels[imp]
+	if !ok {
+		return fmt.Errorf("%s: import %q is not provided by any direct dependency", fileName, imp)
+	}
+	target := label
+	if target == "" {
+		target = "this target"
+	}
+	return fmt.Errorf("%s: import %q is not provided by any direct dependency: add %s to deps of %s", fileName, imp, depLabel, target)
 }
 
 // listStdlibPaths returns a map from standard library import strings to
 // compiled package file paths. This map may be used to write an importcfg file.
+// Empty files are skipped: go_stdlib writes them for packages that aren't
+// built for the target platform.
 func listStdlibPaths(stdlibPath string) (_ map[string]string, err error) {
 	defer func() {
 		if err != nil {
 			err = fmt.Errorf("listing std paths: %w", err)
 		}
 	}()
-	stdlibPath, err = filepath.Abs(stdlibPath)
-	if err != nil {
-		return nil, err
-	}
+	// Paths are relative to the working directory, so they don't depend on
+	// where the build runs.
+	stdlibPath = filepath.Clean(stdlibPath)
 	entries := make(map[string]string)
 	err = filepath.WalkDir(stdlibPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -43,6 +55,11 @@ func listStdlibPaths(stdlibPath string) (_ map[string]string, err error) {
 		if !strings.HasSuffix(path, ".a") || d.IsDir() {
 			return nil
 		}
+		if info, err := d.Info(); err != nil {
+			return err
+		} else if info.Size() == 0 {
+			return nil
+		}
 		imp := strings.TrimPrefix(path, stdlibPath+string(os.PathSeparator))
 		imp = strings.TrimSuffix(imp, ".a")
 		entries[imp] = path
@@ -54,9 +71,71 @@ func listStdlibPaths(stdlibPath string) (_ map[string]string, err error) {
 	return entries, nil
 }
 
+// readStdImportcfg reads an importcfg file written by stdImportcfg and
+// returns a map from standard library package paths to compiled package
+// files. The caller may modify the map.
+//
+// Maps are cached, since a persistent worker may read the same file for
+// many requests.
+func readStdImportcfg(r *request, importcfgPath string) (map[string]string, error) {
+	digest, ok := r.digests[importcfgPath]
+	if !ok {
+		_, archiveMap, err := readImportcfg(importcfgPath)
+		return archiveMap, err
+	}
+	key := stdImportcfgKey{importcfgPath: importcfgPath, digest: digest}
+	if archiveMap, ok := stdImportcfgCache.get(key); ok {
+		return maps.Clone(archiveMap), nil
+	}
+	_, archiveMap, err := readImportcfg(importcfgPath)
+	if err != nil {
+		return nil, err
+	}
+	stdImportcfgCache.add(key, archiveMap)
+	return maps.Clone(archiveMap), nil
+}
+
+// stdImportcfgCache maps stdImportcfgKeys to maps returned by
+// readStdImportcfg. There's one importcfg per toolchain, and a build
+// usually only uses a few.
+var stdImportcfgCache = newLRUCache[stdImportcfgKey, map[string]string](16)
+
+type stdImportcfgKey struct {
+	importcfgPath, digest string
+}
+
+// readImportcfg parses an importcfg file. See writeImportcfg for the meaning
+// of the returned maps.
+func readImportcfg(importcfgPath string) (importMap, archiveMap map[string]string, err error) {
+	data, err := os.ReadFile(importcfgPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	importMap = make(map[string]string)
+	archiveMap = make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		key, value, ok := strings.Cut(strings.TrimSpace(arg), "=")
+		if !ok || (verb != "importmap" && verb != "packagefile") {
+			return nil, nil, fmt.Errorf("%s:%d: malformed line: %q", importcfgPath, i+1, line)
+		}
+		if verb == "importmap" {
+			importMap[key] = value
+		} else {
+			archiveMap[key] = value
+		}
+	}
+	return importMap, archiveMap, nil
+}
+
 // writeTempImportcfg writes a temporary importcfg file. The caller is
-// responsible for deleting it.
-func writeTempImportcfg(archiveMap map[string]string) (string, error) {
+// responsible for deleting it. See writeImportcfg for the meaning of the
+// arguments.
+func writeTempImportcfg(importMap, archiveMap map[string]string) (string, error) {
 	tmpFile, err := os.CreateTemp("", "importcfg-*")
 	if err != nil {
 		return "", err
@@ -66,24 +145,34 @@ func writeTempImportcfg(archiveMap map[string]string) (string, error) {
 		os.Remove(tmpPath)
 		return "", err
 	}
-	if err := writeImportcfg(archiveMap, tmpPath); err != nil {
+	if err := writeImportcfg(importMap, archiveMap, tmpPath); err != nil {
 		os.Remove(tmpPath)
 		return "", err
 	}
 	return tmpPath, nil
 }
 
-func writeImportcfg(archiveMap map[string]string, outPath string) error {
-	pkgPaths := make([]string, 0, len(archiveMap))
-	for pkgPath := range archiveMap {
-		pkgPaths = append(pkgPaths, pkgPath)
-	}
-	sort.Strings(pkgPaths)
-
+// writeImportcfg writes an importcfg file for the compiler or linker.
+// importMap maps import paths, as written in source files, to package paths
+// when they differ; it only matters to the compiler. archiveMap maps package
+// paths to archive files.
+func writeImportcfg(importMap, archiveMap map[string]string, outPath string) error {
 	buf := &bytes.Buffer{}
-	for _, pkgPath := range pkgPaths {
+	for _, importPath := range sortedKeys(importMap) {
+		fmt.Fprintf(buf, "importmap %s=%s\n", importPath, importMap[importPath])
+	}
+	for _, pkgPath := range sortedKeys(archiveMap) {
 		fmt.Fprintf(buf, "packagefile %s=%s\n", pkgPath, archiveMap[pkgPath])
 	}
 
 	return os.WriteFile(outPath, buf.Bytes(), 0666)
 }
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,82 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// instrumentation describes how packages are instrumented to detect bugs
+// at run time. Every package linked into a binary, including packages in
+// the standard library, must be instrumented the same way.
+type instrumentation struct {
+	// race enables the race detector.
+	race bool
+
+	// sanitizer is "msan" for the memory sanitizer, "asan" for the address
+	// sanitizer, or empty. Sanitizers check memory accesses in both Go and
+	// C code, so cgo code is instrumented, too.
+	sanitizer string
+}
+
+// registerFlags adds command line flags that set the fields of in.
+func (in *instrumentation) registerFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&in.race, "race", false, "instrument packages for the race detector; the standard library must be instrumented, too")
+	fs.StringVar(&in.sanitizer, "sanitizer", "", "instrument packages with a sanitizer, msan or asan; the standard library must be instrumented, too")
+}
+
+// check returns an error if the sanitizer is unknown or if incompatible
+// modes are enabled together.
+func (in instrumentation) check() error {
+	switch in.sanitizer {
+	case "", "msan", "asan":
+	default:
+		return fmt.Errorf("invalid sanitizer %q: must be msan or asan", in.sanitizer)
+	}
+	if in.race && in.sanitizer != "" {
+		return fmt.Errorf("-race and -sanitizer %s may not be used together", in.sanitizer)
+	}
+	return nil
+}
+
+// tags returns build tags satisfied by instrumented builds. The go command
+// sets the same tags.
+func (in instrumentation) tags() []string {
+	var tags []string
+	if in.race {
+		tags = append(tags, "race")
+	}
+	if in.sanitizer != "" {
+		tags = append(tags, in.sanitizer)
+	}
+	return tags
+}
+
+// toolFlags returns flags for the Go compiler and linker.
+func (in instrumentation) toolFlags() []string {
+	var flags []string
+	if in.race {
+		flags = append(flags, "-race")
+	}
+	if in.sanitizer != "" {
+		flags = append(flags, "-"+in.sanitizer)
+	}
+	return flags
+}
+
+// cFlags returns flags for the C/C++ compiler, used both when compiling
+// and when linking.
+func (in instrumentation) cFlags() []string {
+	switch in.sanitizer {
+	case "msan":
+		return []string{"-fsanitize=memory"}
+	case "asan":
+		return []string{"-fsanitize=address"}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// langVersion returns the language version to pass to the compiler with
+// -lang, formatted like "go1.21". goVersion is a version set explicitly
+// on the target; if it's empty, the version is read from the go directive
+// in the go.mod file at gomodPath. If both are empty, langVersion returns ""
+// and the compiler uses its own default.
+func langVersion(goVersion, gomodPath string) (string, error) {
+	if goVersion == "" && gomodPath != "" {
+		var err error
+		goVersion, err = readGoModVersion(gomodPath)
+		if err != nil {
+			return "", err
+		}
+	}
+	if goVersion == "" {
+		return "", nil
+	}
+	lang, ok := parseLangVersion(goVersion)
+	if !ok {
+		return "", fmt.Errorf("invalid Go version %q: must be a release like 1.21 or 1.21.0", goVersion)
+	}
+	return lang, nil
+}
+
+// parseLangVersion converts a Go release version like "1.21", "1.21.3", or
+// "go1.21rc1" to a language version like "go1.21". Language versions don't
+// include patch numbers or prerelease suffixes.
+func parseLangVersion(v string) (string, bool) {
+	v = strings.TrimPrefix(v, "go")
+	rest, ok := strings.CutPrefix(v, "1.")
+	if !ok {
+		return "", false
+	}
+	end := 0
+	for end < len(rest) && '0' <= rest[end] && rest[end] <= '9' {
+		end++
+	}
+	minor, err := strconv.Atoi(rest[:end])
+	if err != nil {
+		return "", false
+	}
+	if suffix := rest[end:]; suffix != "" && suffix[0] != '.' && !strings.HasPrefix(suffix, "rc") && !strings.HasPrefix(suffix, "beta") {
+		return "", false
+	}
+	return fmt.Sprintf("go1.%d", minor), true
+}
+
+// readGoModVersion returns the version in the go directive of a go.mod file.
+// If there's no go directive, readGoModVersion returns "go1.16", the
+// version the go command assumes for such modules.
+func readGoModVersion(gomodPath string) (string, error) {
+	f, err := os.Open(gomodPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "go" {
+			continue
+		}
+		if len(fields) != 2 {
+			return "", fmt.Errorf("%s: malformed go directive: %s", gomodPath, scanner.Text())
+		}
+		return fields[1], nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "go1.16", nil
+}
@@ -9,56 +9,135 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
+	"strings"
 )
 
 // link produces an executable file from a main archive file and a list of
-// dependencies (both direct and transitive).
-func link(args []string) error {
+// dependencies (both direct and transitive). If a C/C++ compiler is
+// provided, link uses it as an external linker, which is needed for
+// packages built with cgo.
+func link(r *request, args []string) error {
 	// Process command line arguments.
-	var stdlibPath, mainPath, outPath string
+	var stdImportcfgPath, goos, goarch, mainPath, outPath, unusedInputsPath string
 	var archives []archive
-	fs := flag.NewFlagSet("link", flag.ExitOnError)
-	fs.StringVar(&stdlibPath, "stdlib", "", "path to a directory containing compiled standard library packages")
+	var linkFlags []string
+	var instr instrumentation
+	var cgo cgoConfig
+	fs := flag.NewFlagSet("link", flag.ContinueOnError)
+	fs.SetOutput(r.stderr)
+	r.registerToolFlags(fs)
+	fs.StringVar(&stdImportcfgPath, "stdimportcfg", "", "path to an importcfg file listing compiled standard library packages, written by stdimportcfg")
+	fs.StringVar(&goos, "goos", "", "operating system to compile for; defaults to the host's")
+	fs.StringVar(&goarch, "goarch", "", "architecture to compile for; defaults to the host's")
 	fs.Var(archiveFlag{&archives}, "arc", "information about dependencies (including transitive dependencies), formatted as packagepath=file (may be repeated)")
 	fs.StringVar(&mainPath, "main", "", "path to main package archive file")
 	fs.StringVar(&outPath, "o", "", "path to binary file the linker should produce")
-	fs.Parse(args)
+	fs.StringVar(&unusedInputsPath, "unusedinputsout", "", "if set, path to a file listing dependency archives the linker didn't load")
+	fs.Var(stringListFlag{&linkFlags}, "linkopt", "flag passed to the Go linker (may be repeated)")
+	instr.registerFlags(fs)
+	fs.StringVar(&cgo.cc, "cc", "", "path to the C/C++ compiler, used as an external linker")
+	fs.Var(stringListFlag{&cgo.ldFlags}, "ldflag", "flag passed to the external linker (may be repeated)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
 	if len(fs.Args()) != 0 {
 		return fmt.Errorf("expected 0 positional arguments; got %d", len(fs.Args()))
 	}
+	r.setTargetEnv(goos, goarch)
+	if err := checkToolFlags("linkopt", linkFlags, linkerReservedFlags); err != nil {
+		return err
+	}
+	if err := instr.check(); err != nil {
+		return err
+	}
 
 	// Build an importcfg file that maps package paths to compiled archive files.
 	// This includes the main package, all transitively imported packages listed
 	// with -arc, and all standard library packages. We don't technically need to
 	// list unimported standard library packages, but within this action, there's
 	// no way to know which packages are actually imported.
-	archiveMap, err := listStdlibPaths(stdlibPath)
+	archiveMap, err := readStdImportcfg(r, stdImportcfgPath)
 	if err != nil {
 		return err
 	}
 	for _, arc := range archives {
 		archiveMap[arc.packagePath] = arc.filePath
 	}
-	importcfgPath, err := writeTempImportcfg(archiveMap)
+	importcfgPath, err := writeTempImportcfg(nil, archiveMap)
 	if err != nil {
 		return err
 	}
 	defer os.Remove(importcfgPath)
 
 	// Invoke the linker.
-	return runLinker(mainPath, importcfgPath, outPath)
+	if err := runLinker(r, mainPath, importcfgPath, outPath, linkFlags, instr, cgo); err != nil {
+		return err
+	}
+	if unusedInputsPath != "" {
+		used, err := findLinkedArchives(mainPath, archiveMap, instr)
+		if err != nil {
+			// Consider every archive used.
+			fmt.Fprintf(r.stderr, "link: warning: not listing unused inputs: %v\n", err)
+			used = nil
+		}
+		return writeUnusedInputs(unusedInputsPath, archiveMap, used)
+	}
+	return nil
 }
 
-func runLinker(mainPath, importcfgPath string, outPath string) error {
-	args := []string{"tool", "link", "-importcfg", importcfgPath, "-o", outPath}
+// linkerReservedFlags lists linker flags that runLinker sets.
+// Users may not set these with -linkopt.
+var linkerReservedFlags = []string{"asan", "extld", "extldflags", "importcfg", "linkmode", "msan", "o", "race"}
+
+func runLinker(r *request, mainPath, importcfgPath string, outPath string, linkFlags []string, instr instrumentation, cgo cgoConfig) error {
+	// Sanitizer runtimes are written in C, so they must be linked externally.
+	if instr.sanitizer != "" {
+		if !cgo.enabled() {
+			return fmt.Errorf("-sanitizer %s requires an external linker, but -cc is not set", instr.sanitizer)
+		}
+		cgo.ldFlags = append(cgo.ldFlags[:len(cgo.ldFlags):len(cgo.ldFlags)], instr.cFlags()...)
+	}
+
+	// Don't record GOROOT in the binary. It's a path in Bazel's execroot, which
+	// isn't meaningful at run time and makes outputs differ between machines.
+	args := []string{"-importcfg", importcfgPath, "-X", "runtime.defaultGOROOT=", "-o", outPath}
+	args = append(args, instr.toolFlags()...)
+	if cgo.enabled() {
+		args = append(args, "-linkmode=external", "-extld", cgo.cc)
+		if len(cgo.ldFlags) > 0 {
+			extldflags, err := quoteFlags(cgo.ldFlags)
+			if err != nil {
+				return err
+			}
+			args = append(args, "-extldflags", extldflags)
+		}
+	}
+	args = append(args, linkFlags...)
 	args = append(args, "--", mainPath)
-	goTool, err := findGoTool()
+	linkTool, err := r.toolPath("link")
 	if err != nil {
 		return err
 	}
-	cmd := exec.Command(goTool, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return r.runCommand(nil, linkTool, args...)
+}
+
+// quoteFlags joins a list of flags into a single string that the linker
+// splits back into a list. Flags containing spaces or quotes are quoted.
+// The linker's splitting doesn't support escapes, so a flag containing both
+// single and double quotes can't be quoted, and quoteFlags returns an error.
+func quoteFlags(flags []string) (string, error) {
+	quoted := make([]string, len(flags))
+	for i, f := range flags {
+		switch {
+		case !strings.ContainsAny(f, " \t\n\r'\""):
+			quoted[i] = f
+		case !strings.Contains(f, "'"):
+			quoted[i] = "'" + f + "'"
+		case !strings.Contains(f, `"`):
+			quoted[i] = `"` + f + `"`
+		default:
+			return "", fmt.Errorf("flag %q contains both single and double quotes and cannot be quoted", f)
+		}
+	}
+	return strings.Join(quoted, " "), nil
 }
@@ -0,0 +1,158 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// nogoConfig describes how to run static analysis on a package after it's
+// compiled. The analysis binary is built by the nogo rule. It speaks the same
+// protocol as "go vet -vettool", so it's usually a main package that calls
+// unitchecker.Main from golang.org/x/tools/go/analysis/unitchecker.
+type nogoConfig struct {
+	// path is the analysis binary. Analysis is disabled if it's empty.
+	path string
+
+	// configPath is a JSON file that limits which files each analyzer
+	// reports findings in. It's optional. See readNogoAllowlist.
+	configPath string
+
+	// factsOutPath is where facts about the package are written. Analyzers
+	// may use facts about a dependency, for example, to learn that
+	// a function is a printf wrapper.
+	factsOutPath string
+
+	// depFacts maps package paths of direct dependencies to files
+	// containing their facts.
+	depFacts map[string]string
+}
+
+// registerFlags adds command line flags that set the fields of c.
+func (c *nogoConfig) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.path, "nogo", "", "path to a static analysis binary run on the package after it's compiled")
+	fs.StringVar(&c.configPath, "nogoconfig", "", "path to a JSON file limiting which files each analyzer reports findings in")
+	fs.StringVar(&c.factsOutPath, "factsout", "", "path to a file where the analysis binary should write facts about the package")
+	fs.Var(stringMapFlag{&c.depFacts}, "depfacts", "facts about a direct dependency, formatted as packagepath=file (may be repeated)")
+}
+
+func (c nogoConfig) enabled() bool {
+	return c.path != ""
+}
+
+// runNogo runs the analysis binary on a compiled package. It returns an
+// error listing findings that the allowlist doesn't exclude.
+//
+// Facts are written to cfg.factsOutPath even if the package has no Go
+// sources, since Bazel expects the file to exist.
+func runNogo(r *request, cfg nogoConfig, pkg vetPackage) error {
+	if len(pkg.srcPaths) == 0 {
+		if cfg.factsOutPath != "" {
+			return os.WriteFile(cfg.factsOutPath, nil, 0666)
+		}
+		return nil
+	}
+	allowlist, err := readNogoAllowlist(cfg.configPath)
+	if err != nil {
+		return err
+	}
+	findings, err := runVetTool(r, cfg.path, nil, pkg, cfg.factsOutPath, cfg.depFacts, allowlist.allows)
+	if err != nil {
+		return fmt.Errorf("running nogo: %w", err)
+	}
+	if len(findings) > 0 {
+		return fmt.Errorf("nogo found problems:\n\t%s", strings.Join(findings, "\n\t"))
+	}
+	return nil
+}
+
+// nogoAllowlist limits which files each analyzer reports findings in.
+// It's read from a JSON object mapping analyzer names to objects with
+// the fields below, for example:
+//
+//	{
+//	    "printf": {
+//	        "exclude_files": {
+//	            "third_party/": "not our code"
+//	        }
+//	    }
+//	}
+//
+// Keys of only_files and exclude_files are regular expressions matched
+// against source paths relative to the execution root; values are comments
+// explaining why. Analyzers not in the file report findings everywhere.
+type nogoAllowlist map[string]nogoAnalyzerFiles
+
+type nogoAnalyzerFiles struct {
+	// OnlyFiles, if not empty, limits findings to files matching any
+	// of these patterns.
+	OnlyFiles map[string]string `json:"only_files"`
+
+	// ExcludeFiles suppresses findings in files matching any of these
+	// patterns.
+	ExcludeFiles map[string]string `json:"exclude_files"`
+
+	onlyFiles, excludeFiles []*regexp.Regexp
+}
+
+// readNogoAllowlist reads and parses an allowlist file. If path is empty,
+// readNogoAllowlist returns an empty allowlist.
+func readNogoAllowlist(path string) (nogoAllowlist, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var allowlist nogoAllowlist
+	if err := json.Unmarshal(data, &allowlist); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	compile := func(patterns map[string]string) ([]*regexp.Regexp, error) {
+		var res []*regexp.Regexp
+		for _, pattern := range sortedKeys(patterns) {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, re)
+		}
+		return res, nil
+	}
+	for name, files := range allowlist {
+		if files.onlyFiles, err = compile(files.OnlyFiles); err != nil {
+			return nil, fmt.Errorf("%s: analyzer %s: %w", path, name, err)
+		}
+		if files.excludeFiles, err = compile(files.ExcludeFiles); err != nil {
+			return nil, fmt.Errorf("%s: analyzer %s: %w", path, name, err)
+		}
+		allowlist[name] = files
+	}
+	return allowlist, nil
+}
+
+// allows returns whether findings from the named analyzer should be reported
+// in the file at path.
+func (a nogoAllowlist) allows(analyzer, path string) bool {
+	files, ok := a[analyzer]
+	if !ok {
+		return true
+	}
+	match := func(res []*regexp.Regexp) bool {
+		return slices.ContainsFunc(res, func(re *regexp.Regexp) bool { return re.MatchString(path) })
+	}
+	if len(files.onlyFiles) > 0 && !match(files.onlyFiles) {
+		return false
+	}
+	return !match(files.excludeFiles)
+}
@@ -6,11 +6,14 @@
 package main
 
 import (
+	"fmt"
 	"go/ast"
 	"go/build"
 	"go/parser"
 	"go/token"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -22,15 +25,76 @@ type sourceInfo struct {
 	imports     []string
 	tests       []string
 	hasTestMain bool
+
+	// embedPatterns lists patterns from //go:embed directives.
+	embedPatterns []string
 }
 
-// loadSourceInfo extracts metadata from a source file.
-func loadSourceInfo(bctx *build.Context, fileName string) (sourceInfo, error) {
+// newBuildContext returns a build context for matching files against build
+// constraints for the target platform. goos and goarch default to the
+// host's if empty.
+func newBuildContext(goos, goarch string, tags []string) *build.Context {
+	bctx := build.Default
+	if goos != "" {
+		bctx.GOOS = goos
+	}
+	if goarch != "" {
+		bctx.GOARCH = goarch
+	}
+	if bctx.GOOS != runtime.GOOS || bctx.GOARCH != runtime.GOARCH {
+		// Like the go command, don't assume cgo works when cross-compiling.
+		bctx.CgoEnabled = false
+	}
+	bctx.BuildTags = tags
+	return &bctx
+}
+
+// loadSourceInfo extracts metadata from a source file. Only Go files are
+// parsed; other files are only checked against build constraints.
+//
+// If Bazel sent a digest for the file with a work request, metadata is
+// cached, so it doesn't need to be extracted again for later requests.
+func loadSourceInfo(r *request, bctx *build.Context, fileName string) (sourceInfo, error) {
+	digest, ok := r.digests[fileName]
+	if !ok {
+		return parseSourceInfo(bctx, fileName)
+	}
+	key := sourceInfoKey{
+		fileName: fileName,
+		digest:   digest,
+		context:  fmt.Sprintf("%s/%s cgo=%t tags=%s", bctx.GOOS, bctx.GOARCH, bctx.CgoEnabled, strings.Join(bctx.BuildTags, ",")),
+	}
+	if si, ok := sourceInfoCache.get(key); ok {
+		return si, nil
+	}
+	si, err := parseSourceInfo(bctx, fileName)
+	if err != nil {
+		return sourceInfo{}, err
+	}
+	sourceInfoCache.add(key, si)
+	return si, nil
+}
+
+// sourceInfoCache maps sourceInfoKeys to sourceInfos. Whether a file matches
+// build constraints depends on the build context, so that's part of the key.
+// Metadata is small, so many files may be cached.
+var sourceInfoCache = newLRUCache[sourceInfoKey, sourceInfo](16384)
+
+type sourceInfoKey struct {
+	fileName, digest, context string
+}
+
+// parseSourceInfo extracts metadata from a source file without using
+// the cache.
+func parseSourceInfo(bctx *build.Context, fileName string) (sourceInfo, error) {
 	if match, err := bctx.MatchFile(filepath.Dir(fileName), filepath.Base(fileName)); err != nil {
 		return sourceInfo{}, err
 	} else if !match {
 		return sourceInfo{fileName: fileName}, nil
 	}
+	if !strings.HasSuffix(fileName, ".go") {
+		return sourceInfo{fileName: fileName, match: true}, nil
+	}
 
 	fset := token.NewFileSet()
 	flags := parser.ImportsOnly
@@ -88,5 +152,26 @@ func loadSourceInfo(bctx *build.Context, fileName string) (sourceInfo, error) {
 			si.tests = append(si.tests, decl.Name.Name)
 		}
 	}
+
+	// //go:embed directives are only allowed in files that import "embed".
+	// We only parsed imports above, so parse the whole file again
+	// with comments to find them.
+	if slices.Contains(si.imports, "embed") {
+		tree, err := parser.ParseFile(fset, fileName, nil, parser.ParseComments)
+		if err != nil {
+			return sourceInfo{}, err
+		}
+		for _, cg := range tree.Comments {
+			for _, c := range cg.List {
+				patterns, ok, err := parseEmbedDirective(c.Text)
+				if err != nil {
+					return sourceInfo{}, fmt.Errorf("%s: %w", fset.Position(c.Pos()), err)
+				}
+				if ok {
+					si.embedPatterns = append(si.embedPatterns, patterns...)
+				}
+			}
+		}
+	}
 	return si, nil
 }
@@ -0,0 +1,37 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// stdImportcfg produces an importcfg file listing every compiled package in
+// a standard library directory built by go_stdlib. go_toolchain runs it once
+// for the standard library it's configured with. The compile, link, and test
+// verbs read the file and add entries for their dependencies, so they don't
+// need to list the directory.
+func stdImportcfg(r *request, args []string) error {
+	// Process command line arguments.
+	var stdlibPath, outPath string
+	fs := flag.NewFlagSet("stdimportcfg", flag.ContinueOnError)
+	fs.SetOutput(r.stderr)
+	fs.StringVar(&stdlibPath, "stdlib", "", "path to a directory containing compiled standard library packages")
+	fs.StringVar(&outPath, "o", "", "path to importcfg file to produce")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(fs.Args()) != 0 {
+		return fmt.Errorf("expected 0 positional arguments; got %d", len(fs.Args()))
+	}
+
+	archiveMap, err := listStdlibPaths(stdlibPath)
+	if err != nil {
+		return err
+	}
+	return writeImportcfg(nil, archiveMap, outPath)
+}
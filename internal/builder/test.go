@@ -9,8 +9,9 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"go/build"
+	"maps"
 	"os"
+	"path/filepath"
 	"strings"
 	"text/template"
 )
@@ -21,6 +22,10 @@ type testMainInfo struct {
 	Imports             []testArchiveInfo
 	TestMainPackageName string
 	RunDir              string
+
+	// CoverMode is the coverage counter mode, if any packages linked into
+	// the test were instrumented for coverage.
+	CoverMode string
 }
 
 // testArchiveInfo contains information about a test archive. Tests may build
@@ -41,19 +46,75 @@ type testArchiveInfo struct {
 // sources into internal and external archives, which are compiled separately.
 // test then generates a main .go file that starts the tests and compiles
 // that into the main archive. Finally, test links the test executable.
-func test(args []string) error {
+func test(r *request, args []string) error {
 	// Parse command line arguments.
-	var stdlibPath, packagePath, outPath, runDir string
+	var stdImportcfgPath, goos, goarch, packagePath, outPath, unusedInputsPath, runDir, goVersion, gomodPath, coverMode, testmainCoverPath, pgoProfilePath, vetChecks string
+	var cover bool
+	var instr instrumentation
+	var nogo nogoConfig
 	var directArchives, transitiveArchives []archive
-	fs := flag.NewFlagSet("test", flag.ExitOnError)
-	fs.StringVar(&stdlibPath, "stdlib", "", "path to a directory containing compiled standard library packages")
+	var embedSrcs []embedSrc
+	var tags, gcFlags, linkFlags []string
+	var cgo cgoConfig
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(r.stderr)
+	r.registerToolFlags(fs)
+	fs.StringVar(&stdImportcfgPath, "stdimportcfg", "", "path to an importcfg file listing compiled standard library packages, written by stdimportcfg")
+	fs.StringVar(&goos, "goos", "", "operating system to compile for; defaults to the host's")
+	fs.StringVar(&goarch, "goarch", "", "architecture to compile for; defaults to the host's")
 	fs.StringVar(&packagePath, "p", "default", "string used to import the test library")
-	fs.Var(archiveFlag{&directArchives}, "direct", "information about direct dependencies")
-	fs.Var(archiveFlag{&transitiveArchives}, "transitive", "information about transitive dependencies")
+	fs.Var(archiveFlag{&directArchives}, "direct", "information about direct dependencies, with archives containing export data the test sources are compiled against")
+	fs.Var(archiveFlag{&transitiveArchives}, "transitive", "information about direct and transitive dependencies, with archives containing code linked into the test")
 	fs.StringVar(&outPath, "o", "", "path to binary file to generate")
+	fs.StringVar(&unusedInputsPath, "unusedinputsout", "", "if set, path to a file listing dependency archives not compiled or linked into the test")
 	fs.StringVar(&runDir, "dir", ".", "directory the test binary should change to before running")
-	fs.Parse(args)
+	fs.Var(embedSrcFlag{&embedSrcs}, "embedsrc", "file that may be embedded, formatted as relpath=file, where relpath is relative to the package directory (may be repeated)")
+	fs.Var(tagsFlag{&tags}, "tags", "comma-separated list of build tags to satisfy when filtering sources (may be repeated)")
+	fs.StringVar(&goVersion, "lang", "", "Go language version to compile test sources for, like 1.21")
+	fs.StringVar(&gomodPath, "gomod", "", "path to a go.mod file whose go directive sets the language version if -lang is not set")
+	fs.Var(stringListFlag{&gcFlags}, "goopt", "flag passed to the Go compiler (may be repeated)")
+	fs.Var(stringListFlag{&linkFlags}, "linkopt", "flag passed to the Go linker (may be repeated)")
+	fs.StringVar(&pgoProfilePath, "pgoprofile", "", "path to a CPU profile used for profile-guided optimization")
+	fs.StringVar(&coverMode, "covermode", "", "coverage mode of instrumented packages; if set, the test reports coverage")
+	fs.StringVar(&testmainCoverPath, "testmaincover", "", "path to a Go source file compiled into the test's main package when -covermode is set; it writes coverage reports")
+	fs.BoolVar(&cover, "cover", false, "instrument non-test sources for coverage; requires -covermode")
+	fs.StringVar(&vetChecks, "vet", "", `comma-separated list of vet checks to run on test sources before linking; if empty, the checks "go test" runs by default; "all" for all checks, or "off"`)
+	instr.registerFlags(fs)
+	nogo.registerFlags(fs)
+	fs.StringVar(&cgo.cc, "cc", "", "path to the C/C++ compiler, used as an external linker")
+	fs.Var(stringListFlag{&cgo.ldFlags}, "ldflag", "flag passed to the external linker (may be repeated)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
 	srcPaths := fs.Args()
+	r.setTargetEnv(goos, goarch)
+	lang, err := langVersion(goVersion, gomodPath)
+	if err != nil {
+		return err
+	}
+	if err := checkToolFlags("goopt", gcFlags, compilerReservedFlags); err != nil {
+		return err
+	}
+	if err := checkToolFlags("linkopt", linkFlags, linkerReservedFlags); err != nil {
+		return err
+	}
+	if err := checkCoverMode(coverMode); err != nil {
+		return err
+	}
+	if cover && coverMode == "" {
+		return errors.New("-cover requires -covermode")
+	}
+	if coverMode != "" && testmainCoverPath == "" {
+		return errors.New("-covermode requires -testmaincover")
+	}
+	if err := instr.check(); err != nil {
+		return err
+	}
+	if nogo.factsOutPath != "" {
+		return errors.New("-factsout may not be used with test: facts about test packages aren't saved")
+	}
+	tags = append(tags, instr.tags()...)
+	opts := compilerOptions{lang: lang, pgoProfilePath: pgoProfilePath, instr: instr, gcFlags: gcFlags}
 
 	// Filter sources into two archives: an internal package that gets compiled
 	// together with the library under test, and an external package that
@@ -67,9 +128,9 @@ func test(args []string) error {
 		PackageName: "xtest",
 	}
 	packageName := ""
-	bctx := &build.Default
+	bctx := newBuildContext(goos, goarch, tags)
 	for _, srcPath := range srcPaths {
-		src, err := loadSourceInfo(bctx, srcPath)
+		src, err := loadSourceInfo(r, bctx, srcPath)
 		if err != nil {
 			return err
 		}
@@ -95,29 +156,79 @@ func test(args []string) error {
 	}
 
 	// Build a map from package paths to archive files using the standard
-	// importcfg and -direct command line arguments.
-	archiveMap, err := listStdlibPaths(stdlibPath)
+	// importcfg and -direct command line arguments. Direct dependencies may
+	// have package paths that differ from their import paths.
+	stdArchiveMap, err := readStdImportcfg(r, stdImportcfgPath)
 	if err != nil {
 		return err
 	}
+	archiveMap := maps.Clone(stdArchiveMap)
+	standard := make(map[string]bool)
+	for packagePath := range archiveMap {
+		standard[packagePath] = true
+	}
+	importMap := make(map[string]string)
 	for _, arc := range directArchives {
+		if arc.packagePath != arc.importPath {
+			importMap[arc.importPath] = arc.packagePath
+		}
 		archiveMap[arc.packagePath] = arc.filePath
 	}
 
 	// Compile each archive.
-	mainInfo := testMainInfo{RunDir: runDir}
-	var testArchivePath string
+	mainInfo := testMainInfo{RunDir: runDir, CoverMode: coverMode}
+	var testArchivePath, testFactsPath, testNogoFactsPath string
 	if len(testInfo.srcs) > 0 {
 		mainInfo.Imports = append(mainInfo.Imports, testInfo)
 		if testInfo.hasTestMain {
 			mainInfo.TestMainPackageName = testInfo.PackageName
 		}
 
-		testArchivePath, err = compileTestArchive(testInfo.ImportPath, testInfo.srcPaths, archiveMap)
+		var testCover coverConfig
+		if cover {
+			testCover = coverConfig{
+				mode:        coverMode,
+				packagePath: packagePath,
+				packageName: packageName,
+			}
+		}
+		testArchivePath, err = compileTestArchive(r, testInfo, opts, testCover, embedSrcs, importMap, archiveMap)
 		if err != nil {
 			return err
 		}
 		defer os.Remove(testArchivePath)
+
+		// Like "go test", run vet on the test sources. The compiler has
+		// already reported any type errors. Facts are saved for the
+		// external test package, which may import this one.
+		vetDir, err := os.MkdirTemp("", "vet-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(vetDir)
+		testFactsPath = filepath.Join(vetDir, "facts")
+		pkg := vetPackage{
+			packagePath: packagePath,
+			srcPaths:    testInfo.srcPaths,
+			lang:        lang,
+			importMap:   importMap,
+			archiveMap:  archiveMap,
+			standard:    standard,
+		}
+		if err := runVet(r, vetChecks, pkg, testFactsPath, nil); err != nil {
+			return err
+		}
+
+		// Run static analysis, too, like the compile verb does for
+		// libraries. The external test package gets this package's facts.
+		if nogo.enabled() {
+			testNogo := nogo
+			testNogoFactsPath = filepath.Join(vetDir, "nogofacts")
+			testNogo.factsOutPath = testNogoFactsPath
+			if err := runNogo(r, testNogo, pkg); err != nil {
+				return err
+			}
+		}
 		archiveMap[packagePath] = testArchivePath
 	}
 
@@ -131,26 +242,55 @@ func test(args []string) error {
 			mainInfo.TestMainPackageName = xtestInfo.PackageName
 		}
 
-		xtestArchivePath, err = compileTestArchive(xtestInfo.ImportPath, xtestInfo.srcPaths, archiveMap)
+		xtestArchivePath, err = compileTestArchive(r, xtestInfo, opts, coverConfig{}, embedSrcs, importMap, archiveMap)
 		if err != nil {
 			return err
 		}
 		defer os.Remove(xtestArchivePath)
+
+		var depFacts map[string]string
+		if testFactsPath != "" {
+			depFacts = map[string]string{packagePath: testFactsPath}
+		}
+		pkg := vetPackage{
+			packagePath: packagePath + "_test",
+			srcPaths:    xtestInfo.srcPaths,
+			lang:        lang,
+			importMap:   importMap,
+			archiveMap:  archiveMap,
+			standard:    standard,
+		}
+		if err := runVet(r, vetChecks, pkg, "", depFacts); err != nil {
+			return err
+		}
+		if nogo.enabled() {
+			xtestNogo := nogo
+			if testNogoFactsPath != "" {
+				xtestNogo.depFacts = maps.Clone(nogo.depFacts)
+				if xtestNogo.depFacts == nil {
+					xtestNogo.depFacts = make(map[string]string)
+				}
+				xtestNogo.depFacts[packagePath] = testNogoFactsPath
+			}
+			if err := runNogo(r, xtestNogo, pkg); err != nil {
+				return err
+			}
+		}
 		archiveMap[packagePath+"_test"] = xtestArchivePath
 	}
 
 	// Generate a source file and compile the main package, which imports
 	// the test libraries and starts the test.
-	testmainSrcPath, err := generateTestMain(mainInfo)
+	testmainDir, testmainSrcPath, err := generateTestMain(mainInfo)
 	if err != nil {
 		return err
 	}
-	defer os.Remove(testmainSrcPath)
+	defer os.RemoveAll(testmainDir)
 
 	for _, arc := range transitiveArchives {
 		archiveMap[arc.packagePath] = arc.filePath
 	}
-	importcfgPath, err := writeTempImportcfg(archiveMap)
+	importcfgPath, err := writeTempImportcfg(nil, archiveMap)
 	if err != nil {
 		return err
 	}
@@ -165,19 +305,55 @@ func test(args []string) error {
 	if err := testMainArchiveFile.Close(); err != nil {
 		return err
 	}
-	if err := runCompiler("main", importcfgPath, []string{testmainSrcPath}, testMainArchivePath); err != nil {
+	testmainSrcPaths := []string{testmainSrcPath}
+	if coverMode != "" {
+		testmainSrcPaths = append(testmainSrcPaths, testmainCoverPath)
+	}
+	if err := runCompiler(r, "main", importcfgPath, testmainSrcPaths, testMainArchivePath, compilerOptions{workDir: testmainDir, pgoProfilePath: pgoProfilePath, instr: instr, gcFlags: gcFlags}); err != nil {
 		return err
 	}
 
 	// Link everything together.
-	return runLinker(testMainArchivePath, importcfgPath, outPath)
+	if err := runLinker(r, testMainArchivePath, importcfgPath, outPath, linkFlags, instr, cgo); err != nil {
+		return err
+	}
+
+	// Every package the test archives import is linked, so archives the
+	// linker didn't load weren't read while compiling or vetting either.
+	if unusedInputsPath != "" {
+		used, err := findLinkedArchives(testMainArchivePath, archiveMap, instr)
+		if err != nil {
+			// Consider every archive used.
+			fmt.Fprintf(r.stderr, "test: warning: not listing unused inputs: %v\n", err)
+			used = nil
+		}
+		// Export data archives for direct dependencies aren't listed, so
+		// they're considered used.
+		inputArchiveMap := maps.Clone(stdArchiveMap)
+		for _, arc := range transitiveArchives {
+			inputArchiveMap[arc.packagePath] = arc.filePath
+		}
+		return writeUnusedInputs(unusedInputsPath, inputArchiveMap, used)
+	}
+	return nil
 }
 
-func compileTestArchive(packagePath string, srcPaths []string, archiveMap map[string]string) (string, error) {
-	importcfgPath, err := writeTempImportcfg(archiveMap)
+// compileTestArchive compiles the internal or external test archive. If
+// cover.mode is set, non-test sources are instrumented for coverage.
+func compileTestArchive(r *request, info testArchiveInfo, opts compilerOptions, cover coverConfig, embedSrcs []embedSrc, importMap, archiveMap map[string]string) (string, error) {
+	importcfgPath, err := writeTempImportcfg(importMap, archiveMap)
 	if err != nil {
 		return "", err
 	}
+	defer os.Remove(importcfgPath)
+
+	opts.embedcfgPath, err = writeTempEmbedcfg(info.srcs, embedSrcs)
+	if err != nil {
+		return "", err
+	}
+	if opts.embedcfgPath != "" {
+		defer os.Remove(opts.embedcfgPath)
+	}
 
 	tmpArchiveFile, err := os.CreateTemp("", "*-test.a")
 	if err != nil {
@@ -189,7 +365,36 @@ func compileTestArchive(packagePath string, srcPaths []string, archiveMap map[st
 		return "", err
 	}
 
-	if err := runCompiler(packagePath, importcfgPath, srcPaths, tmpArchivePath); err != nil {
+	srcPaths := info.srcPaths
+	if cover.mode != "" {
+		var libSrcPaths, testSrcPaths []string
+		for _, srcPath := range info.srcPaths {
+			if strings.HasSuffix(srcPath, "_test.go") {
+				testSrcPaths = append(testSrcPaths, srcPath)
+			} else {
+				libSrcPaths = append(libSrcPaths, srcPath)
+			}
+		}
+		if len(libSrcPaths) > 0 {
+			workDir, err := os.MkdirTemp("", "cover-*")
+			if err != nil {
+				os.Remove(tmpArchivePath)
+				return "", err
+			}
+			defer os.RemoveAll(workDir)
+			cover.workDir = workDir
+			opts.workDir = workDir
+			coverPaths, coveragecfgPath, err := runCover(r, cover, libSrcPaths)
+			if err != nil {
+				os.Remove(tmpArchivePath)
+				return "", err
+			}
+			opts.coveragecfgPath = coveragecfgPath
+			srcPaths = append(coverPaths, testSrcPaths...)
+		}
+	}
+
+	if err := runCompiler(r, info.ImportPath, importcfgPath, srcPaths, tmpArchivePath, opts); err != nil {
 		os.Remove(tmpArchivePath)
 		return "", err
 	}
@@ -207,6 +412,10 @@ import (
 	"os"
 	"testing"
 	"testing/internal/testdeps"
+{{if .CoverMode}}
+	"internal/coverage/cfile"
+	_ "runtime/coverage"
+{{end}}
 
 {{range .Imports}}
 	{{.PackageName}} "{{.ImportPath}}"
@@ -221,12 +430,30 @@ var allTests = []testing.InternalTest{
 {{end}}
 }
 
+{{if .CoverMode}}
+// processCoverTestDir and setupSubprocessCoverDir are defined in the file
+// passed with -testmaincover.
+func init() {
+	testdeps.CoverMode = "{{.CoverMode}}"
+	testdeps.CoverSnapshotFunc = cfile.Snapshot
+	testdeps.CoverProcessTestDirFunc = processCoverTestDir
+	testdeps.CoverMarkProfileEmittedFunc = cfile.MarkProfileEmitted
+}
+{{end}}
+
 func main() {
 	if err := os.Chdir("{{.RunDir}}"); err != nil {
 		log.Fatalf("could not change to test directory: %v", err)
 	}
 
 	m := testing.MainStart(testdeps.TestDeps{}, allTests, nil, nil, nil)
+{{if .CoverMode}}
+	if os.Getenv("COVERAGE_OUTPUT_FILE") != "" {
+		if err := setupSubprocessCoverDir(); err != nil {
+			log.Fatalf("could not create coverage directory: %v", err)
+		}
+	}
+{{end}}
 {{if .TestMainPackageName}}
 	{{.TestMainPackageName}}.TestMain(m)
 {{else}}
@@ -235,23 +462,32 @@ func main() {
 }
 `))
 
-func generateTestMain(mainInfo testMainInfo) (testmainPath string, err error) {
-	testmainFile, err := os.CreateTemp("", "*-testmain.go")
+// generateTestMain writes the source file for the test's main package.
+// The file is written to a new temporary directory with a fixed name, so
+// the path recorded in the test binary is always the same once the
+// directory is trimmed. The caller is responsible for deleting the directory.
+func generateTestMain(mainInfo testMainInfo) (testmainDir, testmainPath string, err error) {
+	tmpDir, err := os.MkdirTemp("", "testmain-*")
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	tmpPath := testmainFile.Name() // testmainPath only set on success
 	defer func() {
-		if cerr := testmainFile.Close(); cerr != nil && err == nil {
-			err = cerr
-		}
 		if err != nil {
-			os.Remove(tmpPath)
+			os.RemoveAll(tmpDir)
 		}
 	}()
 
+	tmpPath := filepath.Join(tmpDir, "_testmain.go")
+	testmainFile, err := os.Create(tmpPath)
+	if err != nil {
+		return "", "", err
+	}
 	if err := testmainTpl.Execute(testmainFile, mainInfo); err != nil {
-		return "", err
+		testmainFile.Close()
+		return "", "", err
+	}
+	if err := testmainFile.Close(); err != nil {
+		return "", "", err
 	}
-	return tmpPath, nil
+	return tmpDir, tmpPath, nil
 }
@@ -0,0 +1,307 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+// This file is compiled into the main package of tests built with
+// "bazel coverage", together with the file the builder generates from
+// testmainTpl in test.go. It imports packages internal to the standard
+// library, so it can't be part of the builder itself. The builder reads it
+// with -testmaincover. tests/testmain_cover_test.go checks that it compiles
+// and tests it.
+
+package main
+
+import (
+	"fmt"
+	"internal/coverage"
+	"internal/coverage/cfile"
+	"internal/coverage/decodecounter"
+	"internal/coverage/decodemeta"
+	"internal/coverage/pods"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// subprocessCoverDir is a directory where binaries run by the test write
+// coverage data. go_binary targets built with "bazel coverage" are
+// instrumented and write counters to $GOCOVERDIR when they exit.
+var subprocessCoverDir string
+
+// setupSubprocessCoverDir creates subprocessCoverDir and points GOCOVERDIR
+// at it, so it's inherited by subprocesses. The directory is deleted by
+// processCoverTestDir after its data is merged into the LCOV report.
+func setupSubprocessCoverDir() error {
+	dir, err := os.MkdirTemp("", "gocoverdir-*")
+	if err != nil {
+		return err
+	}
+	subprocessCoverDir = dir
+	return os.Setenv("GOCOVERDIR", dir)
+}
+
+// processCoverTestDir is called at the end of m.Run to report coverage.
+// When run with "bazel coverage", it also writes a coverage profile and
+// converts it to LCOV at the path Bazel expects. This happens within m.Run,
+// so it works even if TestMain calls os.Exit.
+func processCoverTestDir(dir, profilePath, mode, covered string, w io.Writer, selpkgs []string) error {
+	lcovPath := os.Getenv("COVERAGE_OUTPUT_FILE")
+	if lcovPath == "" {
+		return cfile.ProcessCoverTestDir(dir, profilePath, mode, covered, w, selpkgs)
+	}
+	if subprocessCoverDir != "" {
+		defer os.RemoveAll(subprocessCoverDir)
+	}
+	if profilePath == "" {
+		tmpDir, err := os.MkdirTemp("", "coverprofile-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+		profilePath = filepath.Join(tmpDir, "coverprofile")
+	}
+	if err := cfile.ProcessCoverTestDir(dir, profilePath, mode, covered, w, selpkgs); err != nil {
+		return err
+	}
+	return writeLcov(profilePath, subprocessCoverDir, lcovPath)
+}
+
+// writeLcov converts a coverage profile written by the testing package to
+// LCOV format, merging in raw coverage data written by subprocesses to
+// coverDir.
+func writeLcov(profilePath, coverDir, lcovPath string) error {
+	counts, mode, err := readProfileLineCounts(profilePath)
+	if err != nil {
+		return err
+	}
+	if coverDir != "" {
+		if err := addCoverDirLineCounts(counts, mode, coverDir); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(lcovPath, counts.lcov(), 0666)
+}
+
+// lineCounts maps source file names to line numbers to execution counts.
+type lineCounts map[string]map[int]int
+
+// add records count for lines startLine through endLine of file. Blocks
+// counted in the same run may cover the same line, so each line gets the
+// highest count of any block that covers it.
+func (lc lineCounts) add(file string, startLine, endLine, count int) {
+	if lc[file] == nil {
+		lc[file] = make(map[int]int)
+	}
+	for l := startLine; l <= endLine; l++ {
+		if c, ok := lc[file][l]; !ok || count > c {
+			lc[file][l] = count
+		}
+	}
+}
+
+// merge adds counts recorded by a different run. In set mode, counts are
+// only 0 or 1, so the higher count is kept. In count and atomic modes,
+// counts from different runs are summed.
+func (lc lineCounts) merge(other lineCounts, mode string) {
+	for file, counts := range other {
+		if lc[file] == nil {
+			lc[file] = make(map[int]int)
+		}
+		for l, count := range counts {
+			if mode == "set" {
+				lc[file][l] = max(lc[file][l], count)
+			} else {
+				lc[file][l] += count
+			}
+		}
+	}
+}
+
+// lcov formats line counts as an LCOV report.
+func (lc lineCounts) lcov() []byte {
+	files := make([]string, 0, len(lc))
+	for file := range lc {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	b := &strings.Builder{}
+	for _, file := range files {
+		counts := lc[file]
+		lines := make([]int, 0, len(counts))
+		for l := range counts {
+			lines = append(lines, l)
+		}
+		sort.Ints(lines)
+		fmt.Fprintf(b, "SF:%s\n", file)
+		hit := 0
+		for _, l := range lines {
+			fmt.Fprintf(b, "DA:%d,%d\n", l, counts[l])
+			if counts[l] > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(b, "LH:%d\nLF:%d\nend_of_record\n", hit, len(lines))
+	}
+	return []byte(b.String())
+}
+
+// readProfileLineCounts reads line counts and the counter mode from a
+// coverage profile written by the testing package. Each line after the
+// mode line describes a block:
+//
+//	file:startLine.startCol,endLine.endCol numStmts count
+func readProfileLineCounts(profilePath string) (lineCounts, string, error) {
+	data, err := os.ReadFile(profilePath)
+	if err != nil {
+		return nil, "", err
+	}
+	counts := make(lineCounts)
+	var mode string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		if m, ok := strings.CutPrefix(line, "mode:"); ok {
+			mode = strings.TrimSpace(m)
+			continue
+		}
+		i := strings.LastIndex(line, ":")
+		if i < 0 {
+			return nil, "", fmt.Errorf("malformed line in coverage profile: %s", line)
+		}
+		file := line[:i]
+		var startLine, startCol, endLine, endCol, numStmts, count int
+		if _, err := fmt.Sscanf(line[i+1:], "%d.%d,%d.%d %d %d", &startLine, &startCol, &endLine, &endCol, &numStmts, &count); err != nil {
+			return nil, "", fmt.Errorf("malformed line in coverage profile: %s", line)
+		}
+		counts.add(file, startLine, endLine, count)
+	}
+	return counts, mode, nil
+}
+
+// addCoverDirLineCounts reads raw coverage data from a GOCOVERDIR directory
+// and merges it into counts. The directory may contain a "pod" for each
+// instrumented binary: a meta-data file describing its coverable units and
+// counter data files, one from each run.
+func addCoverDirLineCounts(counts lineCounts, mode, dir string) error {
+	podList, err := pods.CollectPods([]string{dir}, false)
+	if err != nil {
+		return fmt.Errorf("reading coverage data from %s: %v", dir, err)
+	}
+	for _, p := range podList {
+		units, err := readPodUnits(p.MetaFile)
+		if err != nil {
+			return err
+		}
+
+		// Record units that never ran, even if there's no counter data.
+		counts.merge(units.lineCounts(nil), mode)
+		for _, counterPath := range p.CounterDataFiles {
+			counters, err := readPodCounters(counterPath)
+			if err != nil {
+				return err
+			}
+			counts.merge(units.lineCounts(counters), mode)
+		}
+	}
+	return nil
+}
+
+// podFuncKey identifies a function in a pod's meta-data and counter files.
+type podFuncKey struct{ pkgIdx, funcIdx uint32 }
+
+// podUnit is a coverable unit described by a pod's meta-data file.
+type podUnit struct {
+	podFuncKey
+	counterIdx         int
+	file               string
+	startLine, endLine int
+}
+
+type podUnits []podUnit
+
+// lineCounts returns line counts for one run, given the counters the run
+// recorded for each function.
+func (units podUnits) lineCounts(counters map[podFuncKey][]uint32) lineCounts {
+	counts := make(lineCounts)
+	for _, u := range units {
+		count := 0
+		if c := counters[u.podFuncKey]; u.counterIdx < len(c) {
+			count = int(c[u.counterIdx])
+		}
+		counts.add(u.file, u.startLine, u.endLine, count)
+	}
+	return counts
+}
+
+// readPodUnits reads the coverable units from a pod's meta-data file.
+func readPodUnits(metaPath string) (podUnits, error) {
+	f, err := os.Open(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := decodemeta.NewCoverageMetaFileReader(f, nil)
+	if err != nil {
+		return nil, fmt.Errorf("reading meta-data file %s: %v", metaPath, err)
+	}
+	var units podUnits
+	var payload []byte
+	for pkgIdx := uint32(0); pkgIdx < uint32(r.NumPackages()); pkgIdx++ {
+		var pd *decodemeta.CoverageMetaDataDecoder
+		pd, payload, err = r.GetPackageDecoder(pkgIdx, payload)
+		if err != nil {
+			return nil, fmt.Errorf("reading meta-data file %s: %v", metaPath, err)
+		}
+		var fd coverage.FuncDesc
+		for funcIdx := uint32(0); funcIdx < pd.NumFuncs(); funcIdx++ {
+			if err := pd.ReadFunc(funcIdx, &fd); err != nil {
+				return nil, fmt.Errorf("reading meta-data file %s: %v", metaPath, err)
+			}
+			for i, u := range fd.Units {
+				if u.Parent != 0 {
+					continue
+				}
+				units = append(units, podUnit{
+					podFuncKey: podFuncKey{pkgIdx, funcIdx},
+					counterIdx: i,
+					file:       fd.Srcfile,
+					startLine:  int(u.StLine),
+					endLine:    int(u.EnLine),
+				})
+			}
+		}
+	}
+	return units, nil
+}
+
+// readPodCounters reads the counters recorded by one run from a counter
+// data file, indexed by package and function.
+func readPodCounters(counterPath string) (map[podFuncKey][]uint32, error) {
+	f, err := os.Open(counterPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := decodecounter.NewCounterDataReader(counterPath, f)
+	if err != nil {
+		return nil, fmt.Errorf("reading counter data file %s: %v", counterPath, err)
+	}
+	counters := make(map[podFuncKey][]uint32)
+	var data decodecounter.FuncPayload
+	for {
+		ok, err := r.NextFunc(&data)
+		if err != nil {
+			return nil, fmt.Errorf("reading counter data file %s: %v", counterPath, err)
+		}
+		if !ok {
+			return counters, nil
+		}
+		// The reader reuses data.Counters for the next function.
+		counters[podFuncKey{data.PkgIdx, data.FuncIdx}] = slices.Clone(data.Counters)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// unusedDep is a direct dependency that no source file imports. Fields
+// are exported so unused dependencies can be written as JSON.
+type unusedDep struct {
+	ImportPath string `json:"importpath"`
+
+	// Label is the Bazel label of the dependency, if known. This is what
+	// needs to be removed from deps.
+	Label string `json:"label,omitempty"`
+}
+
+func (d unusedDep) String() string {
+	if d.Label == "" {
+		return fmt.Sprintf("%q", d.ImportPath)
+	}
+	return fmt.Sprintf("%s (%q)", d.Label, d.ImportPath)
+}
+
+// checkUnusedDepsMode returns an error if mode is not a valid value for
+// the -unuseddeps flag.
+func checkUnusedDepsMode(mode string) error {
+	if mode != "off" && mode != "warn" && mode != "error" {
+		return fmt.Errorf("invalid -unuseddeps mode %q: must be off, warn, or error", mode)
+	}
+	return nil
+}
+
+// findUnusedDeps returns the direct dependencies in archives whose import
+// paths are not in used, in command line order. depLabels maps import paths
+// to Bazel labels.
+func findUnusedDeps(archives []archive, used map[string]bool, depLabels map[string]string) []unusedDep {
+	var unused []unusedDep
+	seen := make(map[string]bool)
+	for _, arc := range archives {
+		if used[arc.importPath] || seen[arc.importPath] {
+			continue
+		}
+		seen[arc.importPath] = true
+		unused = append(unused, unusedDep{ImportPath: arc.importPath, Label: depLabels[arc.importPath]})
+	}
+	return unused
+}
+
+// reportUnusedDeps reports direct dependencies of label, the target being
+// built, that aren't imported by any source file. If mode is "warn", a
+// warning is printed to stderr. If mode is "error", an error is returned.
+//
+// If outPath is not empty, the list is written there as JSON whatever the
+// mode, so that a tool can remove unused dependencies from build files.
+func reportUnusedDeps(r *request, mode, outPath, label string, unused []unusedDep) error {
+	if outPath != "" {
+		out := struct {
+			Label  string      `json:"label,omitempty"`
+			Unused []unusedDep `json:"unused"`
+		}{
+			Label:  label,
+			Unused: unused,
+		}
+		if out.Unused == nil {
+			out.Unused = []unusedDep{}
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(outPath, append(data, '\n'), 0666); err != nil {
+			return err
+		}
+	}
+
+	if len(unused) == 0 || mode == "off" {
+		return nil
+	}
+	target := label
+	if target == "" {
+		target = "this target"
+	}
+	names := make([]string, len(unused))
+	for i, d := range unused {
+		names[i] = d.String()
+	}
+	msg := fmt.Sprintf("direct dependencies of %s are not imported by any source: %s", target, strings.Join(names, ", "))
+	if mode == "error" {
+		return errors.New(msg)
+	}
+	fmt.Fprintf(r.stderr, "compile: warning: %s\n", msg)
+	return nil
+}
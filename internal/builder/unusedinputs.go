@@ -0,0 +1,203 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// writeUnusedInputs writes a list of dependency and standard library archive
+// files an action didn't read, one path per line. Bazel reads the file
+// through the unused_inputs_list parameter of ctx.actions.run and ignores
+// changes to those files when deciding whether the action needs to run again.
+//
+// archiveMap maps package paths to archives the action could have read,
+// and used is the set of archive files it did read. If used is nil,
+// no files are listed.
+func writeUnusedInputs(outPath string, archiveMap map[string]string, used map[string]bool) error {
+	buf := &bytes.Buffer{}
+	if used != nil {
+		for _, pkgPath := range sortedKeys(archiveMap) {
+			if path := archiveMap[pkgPath]; !used[path] {
+				fmt.Fprintln(buf, path)
+			}
+		}
+	}
+	return os.WriteFile(outPath, buf.Bytes(), 0666)
+}
+
+// findLinkedArchives returns the set of archive files the linker loads when
+// linking the archive at mainPath: the archives of packages it transitively
+// imports, plus packages the linker always loads. archiveMap maps package
+// paths to archive files, as in the linker's importcfg.
+//
+// Imports are read from the Go object files in each archive. If that fails,
+// for example, because the object file format changed, findLinkedArchives
+// returns an error, and the caller should warn and assume every archive
+// was used. Tests check the format against the Go version in MODULE.bazel.
+func findLinkedArchives(mainPath string, archiveMap map[string]string, instr instrumentation) (map[string]bool, error) {
+	used := map[string]bool{mainPath: true}
+	queue := []string{mainPath}
+	visit := func(pkgPath string) {
+		if path, ok := archiveMap[pkgPath]; ok && !used[path] {
+			used[path] = true
+			queue = append(queue, path)
+		}
+	}
+
+	// The linker loads these packages even if they aren't imported.
+	// runtime/cgo is only needed with cgo or external linking, but it's
+	// small, and it's safer to consider it used.
+	visit("runtime")
+	visit("runtime/cgo")
+	if instr.race {
+		visit("runtime/race")
+	}
+	if instr.sanitizer != "" {
+		visit("runtime/" + instr.sanitizer)
+	}
+
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		imports, err := readArchiveImports(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, imp := range imports {
+			visit(imp)
+		}
+	}
+	return used, nil
+}
+
+// readArchiveImports returns the paths of packages imported by Go object
+// files in an archive. These are the packages the linker loads when it loads
+// the archive. Object files compiled from other languages are skipped.
+func readArchiveImports(archivePath string) (_ []string, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("reading imports from %s: %w", archivePath, err)
+		}
+	}()
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	} else if string(magic) != arMagic {
+		return nil, errors.New("not an archive")
+	}
+
+	var imports []string
+	header := make([]byte, arHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSpace(string(header[:16]))
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("member %s: invalid size: %w", name, err)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		// Members are aligned to even offsets.
+		if size%2 != 0 {
+			if _, err := r.Discard(1); err != nil && err != io.EOF {
+				return nil, err
+			}
+		}
+
+		// __.PKGDEF holds export data for the compiler. Other members holding
+		// Go code start with the same kind of header line as __.PKGDEF, then
+		// contain an object file in the format the linker reads.
+		if name == "__.PKGDEF" || !bytes.HasPrefix(data, []byte("go object ")) {
+			continue
+		}
+		memberImports, err := readGoObjectImports(data)
+		if err != nil {
+			return nil, fmt.Errorf("member %s: %w", name, err)
+		}
+		imports = append(imports, memberImports...)
+	}
+	return imports, nil
+}
+
+const (
+	arMagic      = "!<arch>\n"
+	arHeaderSize = 60
+
+	// goobjMagic starts a Go object file. The format is defined in
+	// cmd/internal/goobj. If the magic changes, the format may have,
+	// too, so readGoObjectImports fails.
+	goobjMagic = "\x00go120ld"
+)
+
+// readGoObjectImports returns the paths of packages listed in the autolib
+// block of a Go object file. The object file follows a text header in data.
+//
+// The object file starts with goobjMagic, an 8-byte fingerprint, 4 bytes of
+// flags, and a table of 4-byte block offsets. The autolib block comes first.
+// Each entry is a string reference (a 4-byte length and a 4-byte offset),
+// followed by an 8-byte fingerprint. All integers are little-endian.
+func readGoObjectImports(data []byte) ([]string, error) {
+	start := bytes.Index(data, []byte(goobjMagic))
+	if start < 0 {
+		return nil, errors.New("unknown object file format")
+	}
+	obj := data[start:]
+	u32 := func(off uint32) (uint32, error) {
+		if uint64(off)+4 > uint64(len(obj)) {
+			return 0, errors.New("truncated object file")
+		}
+		return binary.LittleEndian.Uint32(obj[off:]), nil
+	}
+
+	const offsetsStart = uint32(len(goobjMagic) + 8 + 4)
+	autolibStart, err := u32(offsetsStart)
+	if err != nil {
+		return nil, err
+	}
+	autolibEnd, err := u32(offsetsStart + 4)
+	if err != nil {
+		return nil, err
+	}
+	const entrySize = 4 + 4 + 8
+	var imports []string
+	for off := autolibStart; off+entrySize <= autolibEnd; off += entrySize {
+		n, err := u32(off)
+		if err != nil {
+			return nil, err
+		}
+		strOff, err := u32(off + 4)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(strOff)+uint64(n) > uint64(len(obj)) {
+			return nil, errors.New("truncated object file")
+		}
+		imports = append(imports, string(obj[strOff:strOff+n]))
+	}
+	return imports, nil
+}
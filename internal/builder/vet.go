@@ -0,0 +1,213 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// vetPackage describes a compiled package to analyze with the vet tool or
+// another tool that speaks the same protocol, like a nogo binary. Maps have
+// the same meaning as the fields of the configuration file "go vet" writes.
+type vetPackage struct {
+	packagePath string
+	srcPaths    []string
+	lang        string
+
+	// importMap maps import paths to package paths. Imports not in
+	// importMap have the same import path and package path.
+	importMap map[string]string
+
+	// archiveMap maps package paths to archive files with export data.
+	archiveMap map[string]string
+
+	// standard is the set of package paths in the standard library.
+	standard map[string]bool
+}
+
+// defaultVetChecks is the list of vet analyzers "go test" runs by default.
+// Like the go command, we only run checks that are almost always right.
+var defaultVetChecks = []string{
+	"atomic",
+	"bools",
+	"buildtag",
+	"directive",
+	"errorsas",
+	"ifaceassert",
+	"nilfunc",
+	"printf",
+	"slog",
+	"stdversion",
+	"stringintconv",
+	"tests",
+}
+
+// runVet runs the vet tool on a compiled test package and returns an error
+// listing any findings. checks is a comma-separated list of analyzers to
+// run. If checks is empty, defaultVetChecks are run. If checks is "all",
+// every analyzer is run, and if it's "off", runVet does nothing.
+//
+// Facts about the package are written to factsOutPath, if it's not empty.
+// depFacts maps package paths of dependencies to their facts files.
+func runVet(r *request, checks string, pkg vetPackage, factsOutPath string, depFacts map[string]string) error {
+	if checks == "off" || len(pkg.srcPaths) == 0 {
+		return nil
+	}
+	var args []string
+	switch checks {
+	case "":
+		for _, check := range defaultVetChecks {
+			args = append(args, "-"+check)
+		}
+	case "all":
+	default:
+		for _, check := range strings.Split(checks, ",") {
+			args = append(args, "-"+strings.TrimSpace(check))
+		}
+	}
+	vetTool, err := r.toolPath("vet")
+	if err != nil {
+		return err
+	}
+	findings, err := runVetTool(r, vetTool, args, pkg, factsOutPath, depFacts, nil)
+	if err != nil {
+		return fmt.Errorf("running vet: %w", err)
+	}
+	if len(findings) > 0 {
+		return fmt.Errorf("vet found problems in %s:\n\t%s", pkg.packagePath, strings.Join(findings, "\n\t"))
+	}
+	return nil
+}
+
+// runVetTool writes a configuration file for pkg and runs a vet-like tool
+// on it with the given arguments. It returns a sorted list of findings
+// formatted for humans. If allows is not nil, findings are only reported
+// when allows returns true for the analyzer name and file.
+func runVetTool(r *request, toolPath string, toolArgs []string, pkg vetPackage, factsOutPath string, depFacts map[string]string, allows func(analyzer, path string) bool) ([]string, error) {
+	workDir, err := os.MkdirTemp("", "vet-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	// Write a configuration file for the tool. This is normally written by
+	// the go command for "go vet". Every import must be mapped, even when
+	// the import path and package path are the same.
+	importMap := map[string]string{"unsafe": "unsafe"}
+	for packagePath := range pkg.archiveMap {
+		importMap[packagePath] = packagePath
+	}
+	for importPath, packagePath := range pkg.importMap {
+		importMap[importPath] = packagePath
+	}
+	if factsOutPath == "" {
+		factsOutPath = filepath.Join(workDir, "facts")
+	}
+	outPath := filepath.Join(workDir, "out.json")
+	vetcfg := struct {
+		ID, Compiler, Dir, ImportPath, GoVersion string
+		GoFiles                                  []string
+		ImportMap, PackageFile, PackageVetx      map[string]string
+		Standard                                 map[string]bool
+		VetxOutput, Stdout                       string
+	}{
+		ID:          pkg.packagePath,
+		Compiler:    "gc",
+		Dir:         filepath.Dir(pkg.srcPaths[0]),
+		ImportPath:  pkg.packagePath,
+		GoVersion:   pkg.lang,
+		GoFiles:     pkg.srcPaths,
+		ImportMap:   importMap,
+		PackageFile: pkg.archiveMap,
+		PackageVetx: depFacts,
+		Standard:    pkg.standard,
+		VetxOutput:  factsOutPath,
+		Stdout:      outPath,
+	}
+	vetcfgData, err := json.Marshal(vetcfg)
+	if err != nil {
+		return nil, err
+	}
+	// The tool requires the .cfg extension.
+	vetcfgPath := filepath.Join(workDir, "vet.cfg")
+	if err := os.WriteFile(vetcfgPath, vetcfgData, 0666); err != nil {
+		return nil, err
+	}
+
+	// With -json, findings are written to the Stdout file, and the tool
+	// exits successfully unless it couldn't run at all.
+	args := slices.Concat(toolArgs, []string{"-json", vetcfgPath})
+	if err := r.runCommand(nil, toolPath, args...); err != nil {
+		return nil, err
+	}
+	return readVetFindings(outPath, allows)
+}
+
+// readVetFindings reads JSON output written by a vet-like tool and returns
+// a sorted list of findings, formatted for humans. An analyzer that failed
+// is reported as a finding, too.
+func readVetFindings(path string, allows func(analyzer, path string) bool) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	// The output maps package IDs to analyzer names to either a list of
+	// diagnostics or an error.
+	var tree map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var findings []string
+	for _, results := range tree {
+		for analyzer, result := range results {
+			var diags []struct {
+				Posn, Message string
+			}
+			if err := json.Unmarshal(result, &diags); err == nil {
+				for _, d := range diags {
+					if allows == nil || allows(analyzer, posnFile(d.Posn)) {
+						findings = append(findings, fmt.Sprintf("%s: %s (%s)", d.Posn, d.Message, analyzer))
+					}
+				}
+				continue
+			}
+			var failure struct {
+				Error string
+			}
+			if err := json.Unmarshal(result, &failure); err != nil {
+				return nil, fmt.Errorf("%s: analyzer %s: %w", path, analyzer, err)
+			}
+			findings = append(findings, fmt.Sprintf("analyzer %s failed: %s", analyzer, failure.Error))
+		}
+	}
+	slices.Sort(findings)
+	return findings, nil
+}
+
+// posnFile returns the file name from a position formatted as
+// "file:line:column" or "file:line".
+func posnFile(posn string) string {
+	for range 2 {
+		i := strings.LastIndexByte(posn, ':')
+		if i < 0 {
+			break
+		}
+		if strings.Trim(posn[i+1:], "0123456789") != "" {
+			break
+		}
+		posn = posn[:i]
+	}
+	return posn
+}
@@ -0,0 +1,133 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// workRequest and workResponse are messages in Bazel's persistent worker
+// protocol, encoded as JSON. See
+// https://bazel.build/remote/persistent for details.
+type workRequest struct {
+	Arguments []string `json:"arguments"`
+	Inputs    []struct {
+		Path   string `json:"path"`
+		Digest string `json:"digest"`
+	} `json:"inputs"`
+
+	// RequestID is 0 for singleplex workers. Multiplex workers may receive
+	// another request before responding to this one.
+	RequestID int `json:"requestId"`
+
+	// SandboxDir is the directory paths in the request are relative to, if
+	// Bazel sandboxes multiplex workers. The builder doesn't support this:
+	// requests are handled concurrently in one process with one working
+	// directory. Actions don't set supports-multiplex-sandboxing, so Bazel
+	// shouldn't send it.
+	SandboxDir string `json:"sandboxDir"`
+}
+
+type workResponse struct {
+	ExitCode  int    `json:"exitCode"`
+	Output    string `json:"output"`
+	RequestID int    `json:"requestId"`
+}
+
+// runWorker reads work requests from in and writes responses to out until
+// in is closed. Requests with non-zero IDs are handled concurrently.
+func runWorker(in io.Reader, out io.Writer) error {
+	// Anything written to standard output would corrupt the protocol, so
+	// send stray output to standard error, which Bazel saves in a log.
+	os.Stdout = os.Stderr
+	log.SetOutput(os.Stderr)
+
+	dec := json.NewDecoder(in)
+	enc := json.NewEncoder(out)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	respond := func(req workRequest) {
+		resp := handleWorkRequest(req)
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(resp); err != nil {
+			log.Printf("writing response to request %d: %v", req.RequestID, err)
+		}
+	}
+	for {
+		var req workRequest
+		if err := dec.Decode(&req); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("reading work request: %w", err)
+		}
+		if req.RequestID == 0 {
+			respond(req)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			respond(req)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// handleWorkRequest performs the action described by a work request. Output
+// from the action and any tools it runs is returned in the response.
+func handleWorkRequest(req workRequest) workResponse {
+	output := &bytes.Buffer{}
+	r := &request{
+		stdout:  output,
+		stderr:  output,
+		digests: make(map[string]string, len(req.Inputs)),
+	}
+	for _, input := range req.Inputs {
+		r.digests[input.Path] = input.Digest
+	}
+	code := 1
+	if req.SandboxDir != "" {
+		fmt.Fprintf(output, "error: sandboxed multiplex work requests are not supported (sandboxDir %q)\n", req.SandboxDir)
+	} else if args, err := expandParamsFile(req.Arguments); err != nil {
+		fmt.Fprintf(output, "error: %v\n", err)
+	} else {
+		code = run(r, args)
+	}
+	return workResponse{
+		ExitCode:  code,
+		Output:    output.String(),
+		RequestID: req.RequestID,
+	}
+}
+
+// expandParamsFile returns the arguments in a params file if args is a single
+// argument naming one, like "@path". Bazel writes params files with one
+// argument per line when a command line might be too long, and it always
+// does so for actions that may run in a worker. Otherwise, args is returned
+// unchanged.
+func expandParamsFile(args []string) ([]string, error) {
+	if len(args) != 1 || !strings.HasPrefix(args[0], "@") {
+		return args, nil
+	}
+	data, err := os.ReadFile(args[0][1:])
+	if err != nil {
+		return nil, err
+	}
+	content := strings.TrimSuffix(string(data), "\n")
+	if content == "" {
+		return nil, nil
+	}
+	return strings.Split(content, "\n"), nil
+}
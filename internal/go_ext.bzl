@@ -6,13 +6,7 @@
 """Internal definitions for the go module extension"""
 
 load(":repo.bzl", "go_download", "go_toolchains")
-
-_PLATFORMS = [
-    ("darwin", "arm64"),
-    ("linux", "amd64"),
-    ("linux", "arm64"),
-    ("windows", "amd64"),
-]
+load(":util.bzl", "PLATFORMS")
 
 _ALLOWED_ARCHIVE_EXTS = [
     ".tar.gz",
@@ -66,7 +60,7 @@ def _go_impl(ctx):
     # is selected.
     ctx.report_progress("declaring toolchains")
     download_repo_names = []
-    for (goos, goarch) in _PLATFORMS:
+    for (goos, goarch) in PLATFORMS:
         compatible_files = [
             file
             for file in files
@@ -87,6 +81,7 @@ def _go_impl(ctx):
             sha256 = sha256,
             goos = goos,
             goarch = goarch,
+            targets = ["{}_{}".format(*platform) for platform in PLATFORMS],
         )
 
     # Declare the go_toolchains repo containing all toolchains. It's important
@@ -95,7 +90,7 @@ def _go_impl(ctx):
     go_toolchains(
         name = "go_toolchains",
         repos = download_repo_names,
-        goos_goarchs = ["{}_{}".format(*platform) for platform in _PLATFORMS],
+        goos_goarchs = ["{}_{}".format(*platform) for platform in PLATFORMS],
     )
 
     return ctx.extension_metadata(
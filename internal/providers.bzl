@@ -16,12 +16,46 @@ GoLibraryInfo = provider(
         "info": """A struct containing information about this library.
         Has the following fields:
             importpath: Name by which the library may be imported.
-            archive: The .a file compiled from the library's sources.
+            importmap: Package path the library was compiled with. Usually
+                the same as importpath, but must be unique in a binary.
+            label: Label of the go_library target. Used in error messages
+                that suggest which target to add to deps.
+            archive: The .a file compiled from the library's sources,
+                containing code for the linker.
+            export: The .x file containing only the library's export
+                data. Packages that import the library are compiled
+                against it, so they don't need to be recompiled when
+                only function bodies change.
+            facts: File containing facts nogo analyzers computed about the
+                library, or None if nogo is not enabled.
+            cgo: Whether the library was compiled with cgo. Executables
+                that link it must use an external linker.
+            cc_info: CcInfo for the library's C/C++ dependencies, or None.
         """,
         "deps": "A depset of info structs for this library's dependencies",
     },
 )
 
+GoStdLibInfo = provider(
+    doc = "Contains information about a standard library compiled by go_stdlib",
+    fields = {
+        "root": """Path of the directory containing compiled packages,
+        relative to the execution root""",
+        "pkgs": """A depset of Files: a compiled .a file for each package,
+        at a path under root matching its import path. Packages not built
+        for the target platform are empty files.""",
+    },
+)
+
+NogoInfo = provider(
+    doc = "Contains information about a static analysis binary built by nogo",
+    fields = {
+        "executable": "The analysis binary. It's run on each compiled package.",
+        "config": """JSON File limiting which files each analyzer reports
+        findings in, or None""",
+    },
+)
+
 # GoToolchainInfo is a dummy provider that serves as documentation for the
 # public interface of the ToolchainInfo provide returned by go_toolchain.
 # Toolchains compatible with @rules_go_simple//:toolchain_type must
@@ -37,6 +71,23 @@ GoToolchainInfo = provider(
             out: output .a file.
             importpath: the path other libraries may use to import this package.
             deps: list of GoLibraryInfo objects for direct dependencies.
+            importmap: the package path to compile with, if different from
+                importpath.
+            embedsrcs: list of Files that may be embedded with //go:embed.
+            gotags: list of build tags used to filter srcs.
+            go_version: Go language version to compile for, like "1.21".
+            gomod: go.mod File whose go directive sets the language
+                version if go_version is empty.
+            gc_goopts: list of additional flags for the Go compiler.
+            cover: whether to instrument srcs for coverage.
+            cgo: whether the package may contain cgo code, C, or C++ sources.
+            cdeps: list of CcInfo objects for C/C++ dependencies.
+            unused_deps_out: optional output JSON File listing direct
+                dependencies that no source imports.
+            facts_out: optional output File where nogo analyzers write facts
+                about the package. Only used if nogo is enabled.
+            export_out: optional output File containing only the package's
+                export data. If set, out only contains code for the linker.
         """,
         "link": """Function that links a Go executable.
 
@@ -45,6 +96,10 @@ GoToolchainInfo = provider(
             out: ouptut executable file.
             main: archive File for the main package.
             deps: list of GoLibraryInfo objects for direct dependencies.
+            gc_linkopts: list of additional flags for the Go linker.
+            cgo: whether the main package was compiled with cgo.
+            cdeps: list of CcInfo objects for C/C++ dependencies of the
+                main package.
         """,
         "build_test": """Function that compiles and links a test executable.
 
@@ -55,6 +110,16 @@ GoToolchainInfo = provider(
             out: output executable file.
             importpath: import path of the internal test archive.
             rundir: directory the test should change to before executing.
+            embedsrcs: list of Files that may be embedded with //go:embed.
+            gotags: list of build tags used to filter srcs.
+            go_version: Go language version to compile srcs for.
+            gomod: go.mod File whose go directive sets the language
+                version if go_version is empty.
+            gc_goopts: list of additional flags for the Go compiler.
+            gc_linkopts: list of additional flags for the Go linker.
+            cover: whether to instrument non-test srcs for coverage.
+            vet: comma-separated list of vet checks to run on srcs, "all",
+                or "off". If empty, the checks "go test" runs are used.
         """,
     },
 )
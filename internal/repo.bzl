@@ -10,10 +10,11 @@ and source files, usually downloaded from an external dependency. Both of the
 repository rules here are used internally by the go module extension.
 
 go_download actually downloads a Go distribution archive and generates a
-BUILD.bazel file that can build the standard library and a builder binary.
+BUILD.bazel file that can build the standard library for each target platform
+and a builder binary.
 
 go_toolchains generates a BUILD.bazel file with all of the toolchain
-definitions.
+definitions: one for each pair of execution and target platforms.
 
 The go module extension declares one go_toolchains repo and multiple
 go_download repos (one for each supported platform). Bazel will only materialize
@@ -52,12 +53,33 @@ def _go_download_impl(ctx):
     constraints = [os_constraint, arch_constraint]
     constraint_str = ",\n        ".join(['"%s"' % c for c in constraints])
 
+    # Declare go_stdlib targets for each platform the distribution may
+    # compile for: one plain, and one for each kind of instrumentation.
+    # Instrumented variants are only built if a toolchain needs them.
+    # All platforms share the same sources; only GOOS and GOARCH differ.
+    # The host platform is always included, since the builder binary
+    # needs it.
+    host = "{}_{}".format(ctx.attr.goos, ctx.attr.goarch)
+    targets = ctx.attr.targets
+    if host not in targets:
+        targets = [host] + targets
+    stdlibs = [_STDLIB_PACKAGES_TEMPLATE.format(
+        packages = "".join(['\n    "{}",'.format(p) for p in _list_stdlib_packages(ctx)]),
+    )]
+    for target in targets:
+        target_goos, target_goarch = target.split("_")
+        stdlibs.append(_STDLIB_BUILD_TEMPLATE.format(
+            goos = target_goos,
+            goarch = target_goarch,
+        ))
+
     substitutions = {
         "{goos}": ctx.attr.goos,
         "{goarch}": ctx.attr.goarch,
         "{exe}": ".exe" if ctx.attr.goos == "windows" else "",
         "{exec_constraints}": constraint_str,
         "{target_constraints}": constraint_str,
+        "{stdlibs}": "\n".join(stdlibs),
     }
     ctx.template(
         "BUILD.bazel",
@@ -65,6 +87,43 @@ def _go_download_impl(ctx):
         substitutions = substitutions,
     )
 
+def _list_stdlib_packages(ctx):
+    """Lists packages in the standard library of the downloaded distribution.
+
+    go_stdlib declares a file for each package, so actions can depend on
+    and ignore packages individually. We can't run the go command here (the
+    distribution may be for a different platform), so every directory under
+    src with a non-test .go file is listed, like "go list std" would without
+    build constraints. Some packages aren't built for every target platform;
+    go_stdlib writes empty files for those.
+
+    Returns:
+        A sorted list of import paths.
+    """
+    src = ctx.path("src")
+    prefix_len = len(str(src)) + 1
+    packages = []
+    dirs = [src]
+
+    # Starlark has no while loops, but the loop ends when dirs is empty.
+    for _ in range(1000000):
+        if not dirs:
+            break
+        dir = dirs.pop()
+        is_package = False
+        for child in dir.readdir():
+            name = child.basename
+            if child.is_dir:
+                if (name == "testdata" or name.startswith(".") or name.startswith("_") or
+                    (str(dir) == str(src) and name == "cmd")):
+                    continue
+                dirs.append(child)
+            elif name.endswith(".go") and not name.endswith("_test.go"):
+                is_package = True
+        if is_package and str(dir) != str(src):
+            packages.append(str(dir)[prefix_len:])
+    return sorted(packages)
+
 go_download = repository_rule(
     implementation = _go_download_impl,
     attrs = {
@@ -86,6 +145,11 @@ go_download = repository_rule(
             values = ["amd64", "arm64"],
             doc = "Host architecture for the Go distribution",
         ),
+        "targets": attr.string_list(
+            default = [],
+            doc = """goos_goarch pairs (like 'linux_arm64') for platforms the
+            distribution should compile the standard library for.""",
+        ),
         "_build_tpl": attr.label(
             default = "//internal:BUILD.bazel.go_download.tpl",
         ),
@@ -93,6 +157,58 @@ go_download = repository_rule(
     doc = "Downloads a standard Go distribution and installs a build file",
 )
 
+_STDLIB_PACKAGES_TEMPLATE = """
+# STDLIB_PACKAGES lists import paths of packages in the standard library.
+# Each go_stdlib target declares a compiled file for each one.
+STDLIB_PACKAGES = [{packages}
+]
+"""
+
+_STDLIB_BUILD_TEMPLATE = """
+go_stdlib(
+    name = "stdlib_{goos}_{goarch}",
+    srcs = [":stdlib_srcs"],
+    goarch = "{goarch}",
+    goos = "{goos}",
+    packages = STDLIB_PACKAGES,
+    tools = [":tools"],
+    visibility = ["//visibility:public"],
+)
+
+go_stdlib(
+    name = "stdlib_{goos}_{goarch}_race",
+    srcs = [":stdlib_srcs"],
+    goarch = "{goarch}",
+    goos = "{goos}",
+    packages = STDLIB_PACKAGES,
+    instrumentation = "race",
+    tools = [":tools"],
+    visibility = ["//visibility:public"],
+)
+
+go_stdlib(
+    name = "stdlib_{goos}_{goarch}_msan",
+    srcs = [":stdlib_srcs"],
+    goarch = "{goarch}",
+    goos = "{goos}",
+    packages = STDLIB_PACKAGES,
+    instrumentation = "msan",
+    tools = [":tools"],
+    visibility = ["//visibility:public"],
+)
+
+go_stdlib(
+    name = "stdlib_{goos}_{goarch}_asan",
+    srcs = [":stdlib_srcs"],
+    goarch = "{goarch}",
+    goos = "{goos}",
+    packages = STDLIB_PACKAGES,
+    instrumentation = "asan",
+    tools = [":tools"],
+    visibility = ["//visibility:public"],
+)
+"""
+
 _TOOLCHAIN_BUILD_HEADER = """# Generated by go_toolchains in @rules_go_simple//internal:repo.bzl
 
 load("@rules_go_simple//:def.bzl", "go_toolchain")
@@ -105,7 +221,7 @@ toolchain(
         {exec_constraints},
     ],
     target_compatible_with = [
-        {exec_constraints},
+        {target_constraints},
     ],
     toolchain = ":{toolchain_name}_impl",
     toolchain_type = "@rules_go_simple//:toolchain_type",
@@ -116,32 +232,47 @@ go_toolchain(
     builder = "{builder}",
     tools = ["{tools}"],
     stdlib = "{stdlib}",
+    stdlib_race = "{stdlib}_race",
+    stdlib_msan = "{stdlib}_msan",
+    stdlib_asan = "{stdlib}_asan",
+    goos = "{goos}",
+    goarch = "{goarch}",
 )
 """
 
 def _go_toolchains_impl(ctx):
+    # Declare a toolchain for each pair of execution and target platforms.
+    # The builder and tools come from the distribution for the execution
+    # platform. That distribution also compiles the standard library for
+    # the target platform.
     lines = [_TOOLCHAIN_BUILD_HEADER]
     for exec_idx, exec_goos_goarch in enumerate(ctx.attr.goos_goarchs):
         repo_name = ctx.attr.repos[exec_idx]
-        exec_goos, exec_goarch = exec_goos_goarch.split("_")
-        exec_constraints = [
-            _GOOS_TO_CONSTRAINT[exec_goos],
-            _GOARCH_TO_CONSTRAINT[exec_goarch],
-        ]
-        exec_constraints_str = ", ".join(['"{}"'.format(c) for c in exec_constraints])
+        exec_constraints_str = _constraints_str(exec_goos_goarch)
         builder = "@{}//:builder".format(repo_name)
         tools = "@{}//:tools".format(repo_name)
-        stdlib = "@{}//:stdlib".format(repo_name)
-        lines.append(_TOOLCHAIN_BUILD_TEMPLATE.format(
-            toolchain_name = exec_goos_goarch,
-            exec_constraints = exec_constraints_str,
-            builder = builder,
-            tools = tools,
-            stdlib = stdlib,
-        ))
+        for target_goos_goarch in ctx.attr.goos_goarchs:
+            target_goos, target_goarch = target_goos_goarch.split("_")
+            stdlib = "@{}//:stdlib_{}".format(repo_name, target_goos_goarch)
+            lines.append(_TOOLCHAIN_BUILD_TEMPLATE.format(
+                toolchain_name = "{}_to_{}".format(exec_goos_goarch, target_goos_goarch),
+                exec_constraints = exec_constraints_str,
+                target_constraints = _constraints_str(target_goos_goarch),
+                builder = builder,
+                tools = tools,
+                stdlib = stdlib,
+                goos = target_goos,
+                goarch = target_goarch,
+            ))
 
     ctx.file("BUILD.bazel", content = "\n".join(lines))
 
+def _constraints_str(goos_goarch):
+    """Formats constraint labels for a goos_goarch pair like 'linux_amd64'."""
+    goos, goarch = goos_goarch.split("_")
+    constraints = [_GOOS_TO_CONSTRAINT[goos], _GOARCH_TO_CONSTRAINT[goarch]]
+    return ", ".join(['"{}"'.format(c) for c in constraints])
+
 go_toolchains = repository_rule(
     implementation = _go_toolchains_impl,
     attrs = {
@@ -10,8 +10,37 @@ dependencies of a library) and create a plan of how to build it (output files,
 actions).
 """
 
-load(":providers.bzl", "GoLibraryInfo")
-load(":util.bzl", "find_go_cmd")
+load("@bazel_skylib//lib:shell.bzl", "shell")
+load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
+load("@bazel_tools//tools/cpp:toolchain_utils.bzl", "find_cpp_toolchain", "use_cpp_toolchain")
+load(":providers.bzl", "GoLibraryInfo", "GoStdLibInfo", "NogoInfo")
+load(":transitions.bzl", "go_config_variant_transition", "go_transition", "nogo_transition")
+load(":util.bzl", "PLATFORMS", "check_sanitizer_compiler", "find_go_cmd")
+
+# Extensions of files that may appear in srcs of go_library and go_binary.
+# Files other than .go and .s files are only allowed in packages that use cgo.
+_SRCS_EXTS = [
+    ".go",
+    ".s",
+    ".S",
+    ".sx",
+    ".c",
+    ".cc",
+    ".cpp",
+    ".cxx",
+    ".h",
+    ".hh",
+    ".hpp",
+    ".hxx",
+]
+
+# Toolchains requested by rules that compile or link Go code. The C/C++
+# toolchain is optional; it's only needed by packages that use cgo.
+_TOOLCHAINS = ["@rules_go_simple//:toolchain_type"] + use_cpp_toolchain(mandatory = False)
+
+# Values allowed for goos and goarch attributes.
+_GOOS_VALUES = sorted({goos: None for goos, _ in PLATFORMS}.keys())
+_GOARCH_VALUES = sorted({goarch: None for _, goarch in PLATFORMS}.keys())
 
 def _go_binary_impl(ctx):
     # Load the toolchain.
@@ -19,12 +48,28 @@ def _go_binary_impl(ctx):
 
     # Declare an output file for the main package and compile it from srcs.
     main_archive = ctx.actions.declare_file("{name}.a".format(name = ctx.label.name))
+    unused_deps = _declare_unused_deps_file(ctx)
+    cgo = _uses_cgo(ctx)
+    cdeps = [dep[CcInfo] for dep in ctx.attr.cdeps]
     go_toolchain.compile(
         ctx,
         srcs = ctx.files.srcs,
         importpath = "main",
         deps = [dep[GoLibraryInfo] for dep in ctx.attr.deps],
         out = main_archive,
+        embedsrcs = ctx.files.embedsrcs,
+        gotags = _gotags(ctx),
+        go_version = ctx.attr.go_version,
+        gomod = ctx.file.gomod,
+        gc_goopts = ctx.attr.gc_goopts,
+        # Like "go build -cover", always instrument the main package in
+        # coverage mode, even if it's not matched by the instrumentation
+        # filter. This links in the runtime support that writes counters for
+        # instrumented dependencies to $GOCOVERDIR when the binary exits.
+        cover = ctx.configuration.coverage_enabled,
+        cgo = cgo,
+        cdeps = cdeps,
+        unused_deps_out = unused_deps,
     )
 
     # Declare an output file for the executable and link it.
@@ -34,6 +79,9 @@ def _go_binary_impl(ctx):
         main = main_archive,
         deps = [dep[GoLibraryInfo] for dep in ctx.attr.deps],
         out = executable,
+        gc_linkopts = ctx.attr.gc_linkopts,
+        cgo = cgo,
+        cdeps = cdeps,
     )
 
     # Return the DefaultInfo provider. This tells Bazel what files should be
@@ -44,11 +92,15 @@ def _go_binary_impl(ctx):
         direct_files = ctx.files.data,
         indirect_targets = ctx.attr.data + ctx.attr.deps,
     )
-    return [DefaultInfo(
-        files = depset([executable]),
-        runfiles = runfiles,
-        executable = executable,
-    )]
+    return [
+        DefaultInfo(
+            files = depset([executable]),
+            runfiles = runfiles,
+            executable = executable,
+        ),
+        OutputGroupInfo(unused_deps = depset([unused_deps])),
+        _instrumented_files_info(ctx),
+    ]
 
 # Declare the go_binary rule. This statement is evaluated during the loading
 # phase when this file is loaded. The function body above is evaluated only
@@ -57,7 +109,7 @@ go_binary = rule(
     implementation = _go_binary_impl,
     attrs = {
         "srcs": attr.label_list(
-            allow_files = [".go"],
+            allow_files = _SRCS_EXTS,
             doc = "Source files to compile for the main package of this binary",
         ),
         "deps": attr.label_list(
@@ -68,10 +120,89 @@ go_binary = rule(
             allow_files = True,
             doc = "Data files available to this binary at run-time",
         ),
+        "embedsrcs": attr.label_list(
+            allow_files = True,
+            doc = """Files that may be embedded into the package with
+            //go:embed directives. Files must be in the package directory
+            or a subdirectory.""",
+        ),
+        "gotags": attr.string_list(
+            doc = """Build tags used to filter srcs, in addition to tags set
+            with --@rules_go_simple//:gotags.""",
+        ),
+        "_gotags": attr.label(
+            default = "//:gotags",
+            providers = [BuildSettingInfo],
+            doc = "Build tags applied to all targets",
+        ),
+        "go_version": attr.string(
+            doc = """Go language version to compile for, like "1.21". The
+            compiler reports an error if code uses language features added
+            in a later version, unless a file has a //go:build constraint
+            for a later version. Defaults to the version in gomod.""",
+        ),
+        "gomod": attr.label(
+            allow_single_file = [".mod"],
+            doc = """go.mod file whose go directive sets the language
+            version if go_version is not set.""",
+        ),
+        "gc_goopts": attr.string_list(
+            doc = """Additional flags to pass to the Go compiler, like
+            ["-N", "-l"] to disable optimizations. Flags the builder sets
+            itself, like -importcfg and -o, may not be used.""",
+        ),
+        "gc_linkopts": attr.string_list(
+            doc = """Additional flags to pass to the Go linker, like
+            ["-s", "-w"] to strip symbols. Flags the builder sets itself,
+            like -importcfg and -o, may not be used.""",
+        ),
+        "cdeps": attr.label_list(
+            providers = [CcInfo],
+            doc = "C/C++ libraries the main package's cgo code depends on",
+        ),
+        "cgo": attr.bool(
+            default = False,
+            doc = """Whether the package uses cgo. This is implied if srcs
+            contains C or C++ files or if cdeps is not empty. In packages
+            that use cgo, assembly files are assembled by the C compiler
+            instead of the Go assembler.""",
+        ),
+        "goos": attr.string(
+            default = "auto",
+            values = ["auto"] + _GOOS_VALUES,
+            doc = """Operating system to build for. If "auto", the target
+            platform is not changed. Must be set together with goarch.""",
+        ),
+        "goarch": attr.string(
+            default = "auto",
+            values = ["auto"] + _GOARCH_VALUES,
+            doc = """Architecture to build for. If "auto", the target
+            platform is not changed. Must be set together with goos.""",
+        ),
+        "race": attr.string(
+            default = "auto",
+            values = ["auto", "on", "off"],
+            doc = """Whether to build with the race detector. If "auto",
+            the //:race build setting is used. "on" or "off" overrides the
+            setting for this target and everything it depends on.""",
+        ),
+        "pgoprofile": attr.label(
+            allow_single_file = True,
+            doc = """CPU profile in pprof format used for profile-guided
+            optimization. Packages this target depends on are compiled with
+            the profile, too, except for the standard library. Overrides the
+            //:pgoprofile build setting.""",
+        ),
+        "_cc_toolchain": attr.label(
+            default = "@bazel_tools//tools/cpp:current_cc_toolchain",
+            doc = "C/C++ toolchain used for cgo, if not resolved as a toolchain",
+        ),
     },
     doc = "Builds an executable program from Go source code",
+    cfg = go_transition,
     executable = True,
-    toolchains = ["@rules_go_simple//:toolchain_type"],
+    fragments = ["cpp"],
+    toolchains = _TOOLCHAINS,
 )
 
 def _go_tool_binary_impl(ctx):
@@ -80,17 +211,16 @@ def _go_tool_binary_impl(ctx):
 
     # Local other input files needed.
     go_cmd = find_go_cmd(ctx.files.tools)
-    stdlib_dir = ctx.file.stdlib
-    inputs = [go_cmd, stdlib_dir] + ctx.files.srcs
+    stdlib = ctx.attr.stdlib[GoStdLibInfo]
 
     # Run the script to compile and link the binary. The order of arguments
     # is important!
-    arguments = [executable.path] + [f.path for f in inputs]
+    arguments = [executable.path, go_cmd.path, stdlib.root] + [f.path for f in ctx.files.srcs]
     ctx.actions.run(
         mnemonic = "GoToolBinary",
         executable = ctx.executable._script,
         arguments = arguments,
-        inputs = inputs,
+        inputs = depset([go_cmd] + ctx.files.srcs, transitive = [stdlib.pkgs]),
         outputs = [executable],
     )
 
@@ -114,7 +244,7 @@ go_tool_binary = rule(
         ),
         "stdlib": attr.label(
             mandatory = True,
-            allow_single_file = True,
+            providers = [GoStdLibInfo],
             doc = "Package files for the standard library compiled by go_stdlib",
         ),
         "_script": attr.label(
@@ -143,12 +273,33 @@ def _go_library_impl(ctx):
 
     # Declare an output file for the library package and compile it from srcs.
     archive = ctx.actions.declare_file("{name}.a".format(name = ctx.label.name))
+    export = ctx.actions.declare_file("{name}.x".format(name = ctx.label.name))
+    unused_deps = _declare_unused_deps_file(ctx)
+    unused_inputs = ctx.actions.declare_file("{name}.unused_inputs".format(name = ctx.label.name))
+    facts = None
+    if toolchain.internal.nogo:
+        facts = ctx.actions.declare_file("{name}.facts".format(name = ctx.label.name))
+    cgo = _uses_cgo(ctx)
+    cdeps = [dep[CcInfo] for dep in ctx.attr.cdeps]
     toolchain.compile(
         ctx,
         srcs = ctx.files.srcs,
         importpath = ctx.attr.importpath,
+        importmap = ctx.attr.importmap,
         deps = [dep[GoLibraryInfo] for dep in ctx.attr.deps],
         out = archive,
+        embedsrcs = ctx.files.embedsrcs,
+        gotags = _gotags(ctx),
+        go_version = ctx.attr.go_version,
+        gomod = ctx.file.gomod,
+        gc_goopts = ctx.attr.gc_goopts,
+        cover = _cover_enabled(ctx),
+        cgo = cgo,
+        cdeps = cdeps,
+        unused_deps_out = unused_deps,
+        unused_inputs_out = unused_inputs,
+        facts_out = facts,
+        export_out = export,
     )
 
     # Return the output file and metadata about the library.
@@ -165,20 +316,31 @@ def _go_library_impl(ctx):
         GoLibraryInfo(
             info = struct(
                 importpath = ctx.attr.importpath,
+                importmap = ctx.attr.importmap or ctx.attr.importpath,
+                label = ctx.label,
                 archive = archive,
+                export = export,
+                facts = facts,
+                cgo = cgo,
+                cc_info = cc_common.merge_cc_infos(cc_infos = cdeps) if cdeps else None,
             ),
             deps = depset(
                 direct = [dep[GoLibraryInfo].info for dep in ctx.attr.deps],
                 transitive = [dep[GoLibraryInfo].deps for dep in ctx.attr.deps],
             ),
         ),
+        OutputGroupInfo(
+            unused_deps = depset([unused_deps]),
+            unused_inputs = depset([unused_inputs]),
+        ),
+        _instrumented_files_info(ctx),
     ]
 
 go_library = rule(
     implementation = _go_library_impl,
     attrs = {
         "srcs": attr.label_list(
-            allow_files = [".go"],
+            allow_files = _SRCS_EXTS,
             doc = "Source files to compile",
         ),
         "deps": attr.label_list(
@@ -189,13 +351,68 @@ go_library = rule(
             allow_files = True,
             doc = "Data files available to binaries using this library",
         ),
+        "embedsrcs": attr.label_list(
+            allow_files = True,
+            doc = """Files that may be embedded into the package with
+            //go:embed directives. Files must be in the package directory
+            or a subdirectory.""",
+        ),
+        "gotags": attr.string_list(
+            doc = """Build tags used to filter srcs, in addition to tags set
+            with --@rules_go_simple//:gotags.""",
+        ),
+        "_gotags": attr.label(
+            default = "//:gotags",
+            providers = [BuildSettingInfo],
+            doc = "Build tags applied to all targets",
+        ),
+        "go_version": attr.string(
+            doc = """Go language version to compile for, like "1.21". The
+            compiler reports an error if code uses language features added
+            in a later version, unless a file has a //go:build constraint
+            for a later version. Defaults to the version in gomod.""",
+        ),
+        "gomod": attr.label(
+            allow_single_file = [".mod"],
+            doc = """go.mod file whose go directive sets the language
+            version if go_version is not set.""",
+        ),
+        "gc_goopts": attr.string_list(
+            doc = """Additional flags to pass to the Go compiler, like
+            ["-N", "-l"] to disable optimizations. Flags the builder sets
+            itself, like -importcfg and -o, may not be used.""",
+        ),
         "importpath": attr.string(
             mandatory = True,
             doc = "Name by which the library may be imported",
         ),
+        "importmap": attr.string(
+            default = "",
+            doc = """Package path the library is compiled with, if different
+            from importpath. Libraries with the same importpath may be linked
+            into the same binary if their importmaps differ, for example,
+            a vendored copy of a package and the original. If empty,
+            importpath is used.""",
+        ),
+        "cdeps": attr.label_list(
+            providers = [CcInfo],
+            doc = "C/C++ libraries this library's cgo code depends on",
+        ),
+        "cgo": attr.bool(
+            default = False,
+            doc = """Whether the package uses cgo. This is implied if srcs
+            contains C or C++ files or if cdeps is not empty. In packages
+            that use cgo, assembly files are assembled by the C compiler
+            instead of the Go assembler.""",
+        ),
+        "_cc_toolchain": attr.label(
+            default = "@bazel_tools//tools/cpp:current_cc_toolchain",
+            doc = "C/C++ toolchain used for cgo, if not resolved as a toolchain",
+        ),
     },
     doc = "Compiles a Go archive from Go sources and dependencies",
-    toolchains = ["@rules_go_simple//:toolchain_type"],
+    fragments = ["cpp"],
+    toolchains = _TOOLCHAINS,
 )
 
 def _go_test_impl(ctx):
@@ -209,6 +426,14 @@ def _go_test_impl(ctx):
         out = executable,
         importpath = ctx.attr.importpath,
         rundir = ctx.label.package,
+        embedsrcs = ctx.files.embedsrcs,
+        gotags = _gotags(ctx),
+        go_version = ctx.attr.go_version,
+        gomod = ctx.file.gomod,
+        gc_goopts = ctx.attr.gc_goopts,
+        gc_linkopts = ctx.attr.gc_linkopts,
+        cover = _cover_enabled(ctx),
+        vet = ctx.attr.vet,
     )
 
     runfiles = _collect_runfiles(
@@ -216,11 +441,14 @@ def _go_test_impl(ctx):
         direct_files = ctx.files.data,
         indirect_targets = ctx.attr.data + ctx.attr.deps,
     )
-    return [DefaultInfo(
-        files = depset([executable]),
-        runfiles = runfiles,
-        executable = executable,
-    )]
+    return [
+        DefaultInfo(
+            files = depset([executable]),
+            runfiles = runfiles,
+            executable = executable,
+        ),
+        _instrumented_files_info(ctx),
+    ]
 
 go_test = rule(
     implementation = _go_test_impl,
@@ -238,36 +466,379 @@ go_test = rule(
             allow_files = True,
             doc = "Data files available to this test",
         ),
+        "embedsrcs": attr.label_list(
+            allow_files = True,
+            doc = """Files that may be embedded into the package with
+            //go:embed directives. Files must be in the package directory
+            or a subdirectory.""",
+        ),
+        "gotags": attr.string_list(
+            doc = """Build tags used to filter srcs, in addition to tags set
+            with --@rules_go_simple//:gotags.""",
+        ),
+        "_gotags": attr.label(
+            default = "//:gotags",
+            providers = [BuildSettingInfo],
+            doc = "Build tags applied to all targets",
+        ),
+        "go_version": attr.string(
+            doc = """Go language version to compile for, like "1.21". The
+            compiler reports an error if code uses language features added
+            in a later version, unless a file has a //go:build constraint
+            for a later version. Defaults to the version in gomod.""",
+        ),
+        "gomod": attr.label(
+            allow_single_file = [".mod"],
+            doc = """go.mod file whose go directive sets the language
+            version if go_version is not set.""",
+        ),
+        "gc_goopts": attr.string_list(
+            doc = """Additional flags to pass to the Go compiler, like
+            ["-N", "-l"] to disable optimizations. Flags the builder sets
+            itself, like -importcfg and -o, may not be used.""",
+        ),
+        "gc_linkopts": attr.string_list(
+            doc = """Additional flags to pass to the Go linker, like
+            ["-s", "-w"] to strip symbols. Flags the builder sets itself,
+            like -importcfg and -o, may not be used.""",
+        ),
         "importpath": attr.string(
             default = "",
             doc = "Name by which test archives may be imported (optional)",
         ),
+        "vet": attr.string(
+            default = "",
+            doc = """Comma-separated list of vet checks to run on the test
+            sources before linking, like "printf,atomic". Findings fail the
+            build. If empty, the same checks "go test" runs by default are
+            used. "all" runs every check, and "off" disables vet.""",
+        ),
+        "goos": attr.string(
+            default = "auto",
+            values = ["auto"] + _GOOS_VALUES,
+            doc = """Operating system to build for. If "auto", the target
+            platform is not changed. Must be set together with goarch.""",
+        ),
+        "goarch": attr.string(
+            default = "auto",
+            values = ["auto"] + _GOARCH_VALUES,
+            doc = """Architecture to build for. If "auto", the target
+            platform is not changed. Must be set together with goos.""",
+        ),
+        "race": attr.string(
+            default = "auto",
+            values = ["auto", "on", "off"],
+            doc = """Whether to build with the race detector. If "auto",
+            the //:race build setting is used. "on" or "off" overrides the
+            setting for this target and everything it depends on.""",
+        ),
+        "pgoprofile": attr.label(
+            allow_single_file = True,
+            doc = """CPU profile in pprof format used for profile-guided
+            optimization. Packages this target depends on are compiled with
+            the profile, too, except for the standard library. Overrides the
+            //:pgoprofile build setting.""",
+        ),
+        "_lcov_merger": attr.label(
+            default = "@bazel_tools//tools/test:lcov_merger",
+            executable = True,
+            cfg = "exec",
+            doc = """Merges coverage reports. Bazel requires this for test
+            rules that support "bazel coverage".""",
+        ),
+        "_cc_toolchain": attr.label(
+            default = "@bazel_tools//tools/cpp:current_cc_toolchain",
+            doc = "C/C++ toolchain used for cgo, if not resolved as a toolchain",
+        ),
     },
     doc = """Compiles and links a Go test executable. Functions with names
 starting with "Test" in files with names ending in "_test.go" will be called
-using the go "testing" framework.""",
+using the go "testing" framework.
+
+When run with "bazel coverage", the coverage report includes coverage from
+go_binary targets that the test runs, for example, binaries in data.""",
+    cfg = go_transition,
+    test = True,
+    fragments = ["cpp"],
+    toolchains = _TOOLCHAINS,
+)
+
+def _nogo_impl(ctx):
+    toolchain = ctx.toolchains["@rules_go_simple//:toolchain_type"]
+
+    # Generate a main package that runs the analyzers using unitchecker,
+    # which speaks the same protocol as "go vet -vettool".
+    analyzers = [dep[GoLibraryInfo] for dep in ctx.attr.deps]
+    unitchecker = ctx.attr.unitchecker[GoLibraryInfo]
+    main_src = ctx.actions.declare_file("{name}_main.go".format(name = ctx.label.name))
+    ctx.actions.write(main_src, _NOGO_MAIN_TEMPLATE.format(
+        unitchecker = unitchecker.info.importpath,
+        imports = "".join([
+            "\tanalyzer{} \"{}\"\n".format(i, a.info.importpath)
+            for i, a in enumerate(analyzers)
+        ]),
+        analyzers = "".join([
+            "\t\tanalyzer{}.Analyzer,\n".format(i)
+            for i in range(len(analyzers))
+        ]),
+    ))
+
+    # Compile and link the analysis binary.
+    main_archive = ctx.actions.declare_file("{name}_main.a".format(name = ctx.label.name))
+    toolchain.compile(
+        ctx,
+        srcs = [main_src],
+        importpath = "main",
+        deps = analyzers + [unitchecker],
+        out = main_archive,
+    )
+    executable = ctx.actions.declare_file(ctx.label.name)
+    toolchain.link(
+        ctx,
+        main = main_archive,
+        deps = analyzers + [unitchecker],
+        out = executable,
+    )
+
+    return [
+        DefaultInfo(
+            files = depset([executable]),
+            executable = executable,
+        ),
+        NogoInfo(
+            executable = executable,
+            config = ctx.file.config,
+        ),
+    ]
+
+_NOGO_MAIN_TEMPLATE = """// Code generated by the nogo rule. DO NOT EDIT.
+
+package main
+
+import (
+\t"{unitchecker}"
+{imports})
+
+func main() {{
+\tunitchecker.Main(
+{analyzers}\t)
+}}
+"""
+
+nogo = rule(
+    implementation = _nogo_impl,
+    attrs = {
+        "deps": attr.label_list(
+            providers = [GoLibraryInfo],
+            doc = """Libraries containing analyzers to run. Each library
+            must declare an exported variable named Analyzer of type
+            *analysis.Analyzer from golang.org/x/tools/go/analysis.""",
+        ),
+        "unitchecker": attr.label(
+            mandatory = True,
+            providers = [GoLibraryInfo],
+            doc = """Library for the package
+            golang.org/x/tools/go/analysis/unitchecker, used to run the
+            analyzers. It must be the same version of golang.org/x/tools
+            the analyzers use.""",
+        ),
+        "config": attr.label(
+            allow_single_file = [".json"],
+            doc = """JSON file that limits which files each analyzer reports
+            findings in. It maps analyzer names to objects with only_files
+            and exclude_files fields. Each of those maps regular expressions,
+            matched against source paths relative to the execution root,
+            to comments explaining why. Analyzers not listed report
+            findings in all files.""",
+        ),
+        "_cc_toolchain": attr.label(
+            default = "@bazel_tools//tools/cpp:current_cc_toolchain",
+            doc = "C/C++ toolchain used for cgo, if not resolved as a toolchain",
+        ),
+    },
+    doc = """Builds a static analysis binary from a set of analyzers.
+
+When the //:nogo build setting points to a nogo target, the analyzers run on
+each Go package as it's compiled, including the internal and external test
+packages of each go_test, and findings fail the build. Facts that analyzers
+compute about a go_library are saved as an extra output, so analyzers can use
+them when checking packages that import it.
+
+The analysis binary is built without nogo, the race detector, sanitizers,
+or a PGO profile.""",
+    cfg = nogo_transition,
+    executable = True,
+    fragments = ["cpp"],
+    toolchains = _TOOLCHAINS,
+)
+
+def _go_cross_binary_impl(ctx):
+    # binary has an outgoing transition, so ctx.attr.binary is a list,
+    # even though there's only one configuration.
+    binary = ctx.attr.binary[0]
+    binary_executable = binary[DefaultInfo].files_to_run.executable
+
+    # Give the executable a name that says what platform it's built for.
+    executable = ctx.actions.declare_file("{}_{}_{}".format(
+        binary.label.name,
+        ctx.attr.goos,
+        ctx.attr.goarch,
+    ))
+    ctx.actions.symlink(
+        output = executable,
+        target_file = binary_executable,
+        is_executable = True,
+    )
+
+    runfiles = ctx.runfiles(files = [executable])
+    runfiles = runfiles.merge(binary[DefaultInfo].default_runfiles)
+    return [DefaultInfo(
+        files = depset([executable]),
+        runfiles = runfiles,
+        executable = executable,
+    )]
+
+go_cross_binary = rule(
+    implementation = _go_cross_binary_impl,
+    attrs = {
+        "binary": attr.label(
+            mandatory = True,
+            executable = True,
+            cfg = go_transition,
+            doc = "go_binary target to build for another platform",
+        ),
+        "goos": attr.string(
+            mandatory = True,
+            values = _GOOS_VALUES,
+            doc = "Operating system to build the binary for",
+        ),
+        "goarch": attr.string(
+            mandatory = True,
+            values = _GOARCH_VALUES,
+            doc = "Architecture to build the binary for",
+        ),
+    },
+    doc = """Builds a go_binary for a specific platform.
+
+go_cross_binary produces an executable named binary_goos_goarch, where binary
+is the name of the wrapped go_binary target. This lets one build produce
+the same binary for several platforms.""",
+    executable = True,
+)
+
+def _go_config_independence_test_impl(ctx):
+    # target has a split transition, so it was built twice, in two different
+    # output directories. Compare the files it produced each time.
+    files_a = sorted(ctx.split_attr.target["a"][DefaultInfo].files.to_list(), key = _short_path)
+    files_b = sorted(ctx.split_attr.target["b"][DefaultInfo].files.to_list(), key = _short_path)
+    if [f.short_path for f in files_a] != [f.short_path for f in files_b]:
+        fail("target produced different files in each configuration")
+
+    # Both copies of each file have the same short path, so they'd collide in
+    # runfiles. Link them into separate directories.
+    links = []
+    script_lines = [
+        "#!/usr/bin/env bash",
+        "status=0",
+    ]
+    for file_a, file_b in zip(files_a, files_b):
+        path = _short_path(file_a)
+        link_a = ctx.actions.declare_file("{}/a/{}".format(ctx.label.name, path))
+        link_b = ctx.actions.declare_file("{}/b/{}".format(ctx.label.name, path))
+        ctx.actions.symlink(output = link_a, target_file = file_a)
+        ctx.actions.symlink(output = link_b, target_file = file_b)
+        links.extend([link_a, link_b])
+        script_lines.append("if ! cmp -s {a} {b}; then echo {msg} >&2; status=1; fi".format(
+            a = shell.quote(link_a.short_path),
+            b = shell.quote(link_b.short_path),
+            msg = shell.quote("{}: output differs when built in a different directory".format(path)),
+        ))
+    script_lines.append("exit $status")
+
+    executable = ctx.actions.declare_file(ctx.label.name + ".bash")
+    ctx.actions.write(
+        output = executable,
+        content = "\n".join(script_lines) + "\n",
+        is_executable = True,
+    )
+    return [DefaultInfo(
+        files = depset([executable]),
+        runfiles = ctx.runfiles(files = links),
+        executable = executable,
+    )]
+
+go_config_independence_test = rule(
+    implementation = _go_config_independence_test_impl,
+    attrs = {
+        "target": attr.label(
+            mandatory = True,
+            cfg = go_config_variant_transition,
+            doc = "Target whose outputs should not depend on its configuration's output directory",
+        ),
+    },
+    doc = """Checks that a target's outputs don't depend on its output directory.
+
+go_config_independence_test builds target and everything it depends on
+(including the standard library) in two configurations that differ only in
+an unread setting, so their outputs are written to different
+bazel-out/<config>/bin directories. It then checks that each output file is
+identical in both builds. Outputs that embed output directory names will
+differ, and the test will fail.
+
+This only checks independence from the configuration hash. Both builds run
+in the same execution root with the same GOROOT, so the test doesn't show
+that outputs are identical across machines or workspaces.""",
     test = True,
-    toolchains = ["@rules_go_simple//:toolchain_type"],
 )
 
 def _go_stdlib_impl(ctx):
-    # Declare an output directory for the compiled standard library, not a file.
-    # The compiled standard library has an .a file for each package with a path
-    # matching the import path (fmt.a, archive/tar.a, and so on). New packages
-    # may be added over time, so we don't know exactly what files will be
-    # produced. It doesn't matter as far as Bazel is concerned though: we can
-    # treat the whole thing as a single File.
+    # Declare an output file for each package in the compiled standard library,
+    # with a path matching the import path (fmt.a, archive/tar.a, and so on).
+    # Declaring files individually rather than one output directory lets
+    # actions report standard library packages they didn't read with
+    # unused_inputs_list, so Bazel doesn't rerun them when those change.
     go_cmd = find_go_cmd(ctx.files.tools)
-    pkg_dir = ctx.actions.declare_directory(ctx.label.name)
+    pkgs = [
+        ctx.actions.declare_file("{}/{}.a".format(ctx.label.name, pkg))
+        for pkg in ctx.attr.packages
+    ]
+    if not pkgs:
+        fail("{}: packages must not be empty".format(ctx.label))
+    pkg_dir = pkgs[0].path[:-len("/{}.a".format(ctx.attr.packages[0]))]
+    packages_file = ctx.actions.declare_file(ctx.label.name + ".packages")
+    ctx.actions.write(packages_file, "\n".join(ctx.attr.packages) + "\n")
+
+    # Instrumented runtimes are built with cgo. Use the configured C compiler
+    # if there is one; otherwise, the go command looks for one on PATH.
+    env = {}
+    transitive_inputs = []
+    if ctx.attr.instrumentation:
+        cc_toolchain = find_cpp_toolchain(ctx, mandatory = False)
+        if cc_toolchain:
+            check_sanitizer_compiler(ctx.label, ctx.attr.instrumentation, cc_toolchain)
+            env["CC"] = cc_toolchain.compiler_executable
+            transitive_inputs.append(cc_toolchain.all_files)
+
     ctx.actions.run(
         mnemonic = "GoStdLib",
         executable = ctx.executable._script,
-        arguments = [go_cmd.path, pkg_dir.path],
-        inputs = ctx.files.srcs + ctx.files.tools,
-        outputs = [pkg_dir],
+        arguments = [
+            go_cmd.path,
+            pkg_dir,
+            ctx.attr.goos,
+            ctx.attr.goarch,
+            packages_file.path,
+            ctx.attr.instrumentation,
+        ],
+        inputs = depset(ctx.files.srcs + ctx.files.tools + [packages_file], transitive = transitive_inputs),
+        outputs = pkgs,
+        env = env,
     )
 
-    return [DefaultInfo(files = depset([pkg_dir]))]
+    return [
+        DefaultInfo(files = depset(pkgs)),
+        GoStdLibInfo(root = pkg_dir, pkgs = depset(pkgs)),
+    ]
 
 go_stdlib = rule(
     implementation = _go_stdlib_impl,
@@ -282,6 +853,28 @@ go_stdlib = rule(
             mandatory = True,
             doc = "Executable files that are part of a Go distribution",
         ),
+        "goos": attr.string(
+            mandatory = True,
+            doc = "Operating system to compile the standard library for",
+        ),
+        "goarch": attr.string(
+            mandatory = True,
+            doc = "Architecture to compile the standard library for",
+        ),
+        "packages": attr.string_list(
+            mandatory = True,
+            doc = """Import paths of packages in the standard library. A file
+            is declared for each. Packages that aren't built for the target
+            platform are written as empty files.""",
+        ),
+        "instrumentation": attr.string(
+            default = "",
+            values = ["", "race", "msan", "asan"],
+            doc = """How to instrument the standard library: for the race
+            detector, the memory sanitizer, the address sanitizer, or not
+            at all. Instrumented runtimes require cgo, so a C compiler for
+            the target platform must be available.""",
+        ),
         "_script": attr.label(
             allow_single_file = True,
             executable = True,
@@ -289,11 +882,60 @@ go_stdlib = rule(
             default = ":stdlib.sh",
             doc = "Script that compiles the Go standard library",
         ),
+        "_cc_toolchain": attr.label(
+            default = "@bazel_tools//tools/cpp:current_cc_toolchain",
+            doc = "C/C++ toolchain used for instrumented runtimes, if not resolved as a toolchain",
+        ),
     },
     doc = """Internal rule needed to build the standard library. Needed by
 go_tool_binary and the rest of the toolchain.""",
+    fragments = ["cpp"],
+    toolchains = use_cpp_toolchain(mandatory = False),
 )
 
+def _short_path(file):
+    """Returns a file's short path, with external repos under "external"."""
+    if file.short_path.startswith("../"):
+        return "external/" + file.short_path[len("../"):]
+    return file.short_path
+
+def _cover_enabled(ctx):
+    """Returns whether a target's sources should be instrumented for coverage."""
+    return ctx.configuration.coverage_enabled and ctx.coverage_instrumented()
+
+def _instrumented_files_info(ctx):
+    """Tells Bazel which of a target's files may be instrumented for coverage.
+
+    Binaries in data are included, since tests may run them and collect
+    their coverage.
+    """
+    return coverage_common.instrumented_files_info(
+        ctx,
+        source_attributes = ["srcs"],
+        dependency_attributes = ["deps", "data"],
+        extensions = ["go"],
+    )
+
+def _declare_unused_deps_file(ctx):
+    """Declares a JSON file listing a target's direct dependencies that no
+    source file imports. It's built with --output_groups=unused_deps.
+    """
+    return ctx.actions.declare_file("{name}.unused_deps.json".format(name = ctx.label.name))
+
+def _gotags(ctx):
+    """Returns the build tags used to filter a target's sources."""
+    return ctx.attr._gotags[BuildSettingInfo].value + ctx.attr.gotags
+
+def _uses_cgo(ctx):
+    """Returns whether a go_library or go_binary should be built with cgo.
+
+    Assembly files and headers don't imply cgo. Without cgo, .S files are
+    preprocessed and assembled by the Go assembler, which may include headers.
+    """
+    return (ctx.attr.cgo or
+            bool(ctx.attr.cdeps) or
+            any([f.extension in ("c", "cc", "cpp", "cxx") for f in ctx.files.srcs]))
+
 def _collect_runfiles(ctx, direct_files, indirect_targets):
     """Builds a runfiles object for the current target.
 
@@ -9,6 +9,35 @@ set -o errexit -o nounset -o pipefail
 go_cmd="$1"
 pkg_dir="$2"
 
+# The standard library is compiled for the target platform, which may be
+# different from the platform this script runs on.
+export GOOS="$3"
+export GOARCH="$4"
+
+# The list of packages go_stdlib declared outputs for, one per line.
+packages_file="$5"
+
+# The standard library may be instrumented for the race detector or
+# a sanitizer, which requires cgo.
+build_flags=()
+instrumentation="${6:-}"
+case "$instrumentation" in
+  "") ;;
+  race | msan | asan)
+    export CGO_ENABLED=1
+    build_flags+=("-$instrumentation")
+    # The C compiler path may be relative to the execution root, but the go
+    # command runs it in each package's directory.
+    if [[ -n "${CC:-}" && "$CC" == */* && "$CC" != /* ]]; then
+      export CC="$PWD/$CC"
+    fi
+    ;;
+  *)
+    echo "unknown instrumentation mode: $instrumentation" >&2
+    exit 1
+    ;;
+esac
+
 # Create the GOCACHE and GOROOT directories, and delete them on exit.
 # Both must be temporary directories with random names. This script may run
 # in multiple concurrent actions (if we're building for multiple platforms),
@@ -32,18 +61,37 @@ cp -RLl "$orig_goroot"/* "$GOROOT"
 
 # Compile the packages in the standard library.
 # Instead of 'go build std' we use 'go list -export std' because we want to
-# know the names of the compiled files.
+# know the names of the compiled files. -trimpath keeps the temporary GOROOT
+# out of the compiled files, so they're the same on every machine.
 mkdir -p "$pkg_dir"
 pkg_list="$(mktemp -t pkg_list)"
 cleanup_paths+=("$pkg_list")
-"$go_cmd" list -export -f '{{.ImportPath}}={{.Export}}' std >"$pkg_list"
+"$go_cmd" list -trimpath ${build_flags[@]+"${build_flags[@]}"} -export -f '{{.ImportPath}}={{.Export}}' std >"$pkg_list"
 
-# Move the compiled files out of the cache.
+# Move the compiled files out of the cache. Every compiled package must have
+# a declared output; if not, the Go distribution has a package the package
+# list in the go_download repository doesn't know about.
 while IFS='=' read pkg_path cache_file; do
   if [[ -z $cache_file ]]; then
     continue # skip fake packages like unsafe
   fi
+  if ! grep -qxF "$pkg_path" "$packages_file"; then
+    echo "standard library package $pkg_path was compiled but not declared in go_stdlib packages" >&2
+    exit 1
+  fi
   pkg_file="$pkg_dir/$pkg_path.a"
   mkdir -p "$(dirname "$pkg_file")"
   mv "$cache_file" "$pkg_file"
 done <"$pkg_list"
+
+# Bazel requires every declared output to exist. Write an empty file for each
+# package that wasn't compiled for this platform (syscall/js on linux, for
+# example). Importing one of these fails to compile as it would with
+# the go command.
+while read pkg_path; do
+  pkg_file="$pkg_dir/$pkg_path.a"
+  if [[ ! -e $pkg_file ]]; then
+    mkdir -p "$(dirname "$pkg_file")"
+    : >"$pkg_file"
+  fi
+done <"$packages_file"
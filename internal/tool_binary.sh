@@ -13,15 +13,19 @@ shift 3
 
 # Generate an importcfg file for the standard library. go_tool_binary is only
 # allowed to import packages in the standard library, so this has everything
-# we need. We don't know which packages it imports, so we include everything.
+# we need. We don't know which packages it imports, so we include everything
+# except empty files for packages not built on the target platform.
+# Paths are relative to the working directory, so the file doesn't depend on
+# where the build runs.
 importcfg="$(mktemp -t importcfg)"
-for file in $(find -L "$stdlib_dir" -type f); do
+for file in $(find -L "$stdlib_dir" -type f -size +0); do
   without_suffix="${file%.a}"
   pkg_path="${without_suffix#${stdlib_dir}/}"
-  abs_file="$PWD/$file"
-  printf 'packagefile %s=%s\n' "$pkg_path" "$abs_file" >>"$importcfg"
+  printf 'packagefile %s=%s\n' "$pkg_path" "$file" >>"$importcfg"
 done
 
-# Compile and link the tool binary.
-"$go_cmd" tool compile -importcfg "$importcfg" -p main -o "$executable.a" $@
-"$go_cmd" tool link -importcfg "$importcfg" -o "$executable" "$executable.a"
+# Compile and link the tool binary. -trimpath removes the working directory
+# from recorded source paths, and clearing runtime.defaultGOROOT keeps GOROOT
+# out of the binary, so the output is the same on every machine.
+"$go_cmd" tool compile -importcfg "$importcfg" -trimpath "$PWD" -p main -o "$executable.a" $@
+"$go_cmd" tool link -importcfg "$importcfg" -X runtime.defaultGOROOT= -o "$executable" "$executable.a"
@@ -14,19 +14,87 @@ load(
     "@bazel_skylib//lib:paths.bzl",
     "paths",
 )
+load("@bazel_skylib//rules:common_settings.bzl", "BuildSettingInfo")
 load(
     ":actions.bzl",
     "go_build_test",
     "go_compile",
     "go_link",
 )
-load(":util.bzl", "find_go_cmd")
+load(":providers.bzl", "GoStdLibInfo", "NogoInfo")
+load(":util.bzl", "INSTRUMENTATION_PLATFORMS", "find_go_cmd")
 
 def _go_toolchain_impl(ctx):
     # Find important files and paths.
     go_cmd = find_go_cmd(ctx.files.tools)
     env = {"GOROOT": paths.dirname(paths.dirname(go_cmd.path))}
 
+    # The builder runs the compiler, linker, and other tools in pkg/tool
+    # directly, and the assembler needs headers in pkg/include. Actions only
+    # need these files, not the whole distribution.
+    tools = {}
+    asm_headers = []
+    for f in ctx.files.tools:
+        if "/pkg/tool/" in f.path:
+            tools[paths.split_extension(f.basename)[0]] = f
+        elif "/pkg/include/" in f.path:
+            asm_headers.append(f)
+
+    # When the race detector or a sanitizer is enabled, everything including
+    # the standard library must be instrumented.
+    race = ctx.attr._race[BuildSettingInfo].value
+    sanitizer = ctx.attr._sanitizer[BuildSettingInfo].value
+    if sanitizer == "none":
+        sanitizer = ""
+    if race and sanitizer:
+        fail("{}: the race detector may not be used together with {}".format(ctx.label, sanitizer))
+    instrumentation = "race" if race else sanitizer
+    stdlib_target = ctx.attr.stdlib
+    if instrumentation:
+        if (ctx.attr.goos, ctx.attr.goarch) not in INSTRUMENTATION_PLATFORMS[instrumentation]:
+            fail("{}: {} is not supported on {}/{}".format(ctx.label, instrumentation, ctx.attr.goos, ctx.attr.goarch))
+        stdlib_target = getattr(ctx.attr, "stdlib_" + instrumentation)
+        if not stdlib_target:
+            fail("{}: {} is not supported: stdlib_{} is not set".format(ctx.label, instrumentation, instrumentation))
+
+    # List the compiled standard library packages in an importcfg file, so
+    # compile, link, and test actions don't each need to list the directory.
+    # Ideally the importcfg would be an output of go_stdlib, built once per
+    # standard library, but go_stdlib can't run the builder: go_tool_binary
+    # compiles the builder against the host go_stdlib, so the host go_stdlib
+    # would depend on itself. Instead, each go_toolchain writes an importcfg
+    # for the standard library it's configured with. This action is cheap
+    # and shared by all targets using the toolchain.
+    stdlib_importcfg = ctx.actions.declare_file(ctx.label.name + "_stdlib.importcfg")
+    args = ctx.actions.args()
+    args.add("stdimportcfg")
+    stdlib_info = stdlib_target[GoStdLibInfo]
+    args.add("-stdlib", stdlib_info.root)
+    args.add("-o", stdlib_importcfg)
+    ctx.actions.run(
+        mnemonic = "GoStdImportcfg",
+        executable = ctx.executable.builder,
+        arguments = [args],
+        inputs = stdlib_info.pkgs,
+        outputs = [stdlib_importcfg],
+    )
+    stdlib = struct(
+        root = stdlib_info.root,
+        pkgs = stdlib_info.pkgs,
+        importcfg = stdlib_importcfg,
+    )
+
+    # Packages may be compiled with a profile for profile-guided optimization.
+    # The build setting points to an empty file group when no profile is set.
+    pgoprofiles = ctx.files._pgoprofile
+    if len(pgoprofiles) > 1:
+        fail("{}: pgoprofile must be a single file; got {}".format(ctx.label, ctx.attr._pgoprofile.label))
+    pgoprofile = pgoprofiles[0] if pgoprofiles else None
+
+    # Packages may be checked with a static analysis binary built by nogo.
+    # The build setting points to an empty file group when nogo is disabled.
+    nogo = ctx.attr._nogo[NogoInfo] if NogoInfo in ctx.attr._nogo else None
+
     # Return a TooclhainInfo provider. This is the object that rules get
     # when they ask for the toolchain.
     return [platform_common.ToolchainInfo(
@@ -43,8 +111,17 @@ def _go_toolchain_impl(ctx):
             go_cmd = go_cmd,
             env = env,
             builder = ctx.executable.builder,
-            tools = ctx.files.tools,
-            stdlib = ctx.file.stdlib,
+            tools = tools,
+            asm_headers = asm_headers,
+            stdlib = stdlib,
+            goos = ctx.attr.goos,
+            goarch = ctx.attr.goarch,
+            race = race,
+            sanitizer = sanitizer,
+            pgoprofile = pgoprofile,
+            unused_deps = ctx.attr._unused_deps[BuildSettingInfo].value,
+            nogo = nogo,
+            testmain_cover = ctx.file._testmain_cover,
         ),
     )]
 
@@ -63,10 +140,70 @@ go_toolchain = rule(
         ),
         "stdlib": attr.label(
             mandatory = True,
-            allow_single_file = True,
+            providers = [GoStdLibInfo],
             cfg = "target",
             doc = "Package files for the standard library compiled by go_stdlib",
         ),
+        "stdlib_race": attr.label(
+            providers = [GoStdLibInfo],
+            cfg = "target",
+            doc = """Package files for the standard library compiled by
+            go_stdlib with instrumentation = "race". Used instead of stdlib
+            when the race detector is enabled.""",
+        ),
+        "stdlib_msan": attr.label(
+            providers = [GoStdLibInfo],
+            cfg = "target",
+            doc = """Package files for the standard library compiled by
+            go_stdlib with instrumentation = "msan". Used instead of stdlib
+            when the memory sanitizer is enabled.""",
+        ),
+        "stdlib_asan": attr.label(
+            providers = [GoStdLibInfo],
+            cfg = "target",
+            doc = """Package files for the standard library compiled by
+            go_stdlib with instrumentation = "asan". Used instead of stdlib
+            when the address sanitizer is enabled.""",
+        ),
+        "goos": attr.string(
+            mandatory = True,
+            doc = "Operating system the toolchain compiles for. Must match stdlib.",
+        ),
+        "goarch": attr.string(
+            mandatory = True,
+            doc = "Architecture the toolchain compiles for. Must match stdlib.",
+        ),
+        "_race": attr.label(
+            default = "//:race",
+            providers = [BuildSettingInfo],
+            doc = "Whether the race detector is enabled",
+        ),
+        "_sanitizer": attr.label(
+            default = "//:sanitizer",
+            providers = [BuildSettingInfo],
+            doc = "Sanitizer to instrument packages with, if any",
+        ),
+        "_nogo": attr.label(
+            default = "//:nogo",
+            cfg = "exec",
+            doc = "Static analysis binary run on each package, built by nogo",
+        ),
+        "_unused_deps": attr.label(
+            default = "//:unused_deps",
+            providers = [BuildSettingInfo],
+            doc = "How to report direct dependencies that aren't imported",
+        ),
+        "_testmain_cover": attr.label(
+            default = "//internal/builder:testmain/cover.go",
+            allow_single_file = [".go"],
+            doc = """Source file compiled into the main package of tests
+            built with coverage. It writes LCOV reports.""",
+        ),
+        "_pgoprofile": attr.label(
+            default = "//:pgoprofile",
+            allow_files = True,
+            doc = "CPU profile used for profile-guided optimization, if any",
+        ),
     },
     doc = "Gathers functions and file lists needed for a Go toolchain",
 )
@@ -0,0 +1,113 @@
+# Copyright Jay Conrod. All rights reserved.
+
+# This file is part of rules_go_simple. Use of this source code is governed by
+# the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+"""Configuration transitions for Go rules.
+
+A transition changes the configuration (build settings and command line
+options) that a target is built in. Transitions let us build a binary for
+a platform other than the one requested with --platforms, or with the race
+detector enabled for one target but not others.
+"""
+
+load(":util.bzl", "PLATFORMS")
+
+# Label of the race build setting, formatted so it refers to this module
+# even when loaded from another one.
+_RACE = str(Label("//:race"))
+_SANITIZER = str(Label("//:sanitizer"))
+_PGOPROFILE = str(Label("//:pgoprofile"))
+_NOGO = str(Label("//:nogo"))
+
+def _go_transition_impl(settings, attr):
+    return {
+        "//command_line_option:platforms": _platforms(settings, attr),
+        _RACE: _race(settings, attr),
+        _PGOPROFILE: _pgoprofile(settings, attr),
+    }
+
+def _platforms(settings, attr):
+    goos = attr.goos
+    goarch = attr.goarch
+    if goos == "auto" and goarch == "auto":
+        return settings["//command_line_option:platforms"]
+    if goos == "auto" or goarch == "auto":
+        fail("goos and goarch must be set together; got goos = {}, goarch = {}".format(goos, goarch))
+    if (goos, goarch) not in PLATFORMS:
+        fail("unsupported platform: {}/{}".format(goos, goarch))
+    return str(Label("//:{}_{}".format(goos, goarch)))
+
+def _race(settings, attr):
+    race = getattr(attr, "race", "auto")
+    if race == "on":
+        return True
+    if race == "off":
+        return False
+    return settings[_RACE]
+
+def _pgoprofile(settings, attr):
+    pgoprofile = getattr(attr, "pgoprofile", None)
+    if pgoprofile:
+        return str(pgoprofile)
+    return settings[_PGOPROFILE]
+
+go_transition = transition(
+    implementation = _go_transition_impl,
+    inputs = ["//command_line_option:platforms", _RACE, _PGOPROFILE],
+    outputs = ["//command_line_option:platforms", _RACE, _PGOPROFILE],
+)
+"""Sets the target platform, race mode, and PGO profile based on attributes.
+
+The transition may be attached to a rule (an incoming transition) or to
+a label attribute (an outgoing transition). Either way, it reads goos and
+goarch attributes from the rule it's attached to. If both are "auto",
+the target platform is unchanged. If the rule has a race attribute set to
+"on" or "off", the race build setting is changed to match. If the rule has
+a pgoprofile attribute, the pgoprofile build setting is changed to point to
+it, so the target's dependencies are compiled with the profile, too.
+"""
+
+def _nogo_transition_impl(settings, attr):
+    return {
+        _NOGO: str(Label("//internal:no_nogo")),
+        _RACE: False,
+        _SANITIZER: "none",
+        _PGOPROFILE: str(Label("//internal:no_pgoprofile")),
+    }
+
+nogo_transition = transition(
+    implementation = _nogo_transition_impl,
+    inputs = [],
+    outputs = [_NOGO, _RACE, _SANITIZER, _PGOPROFILE],
+)
+"""Builds a nogo analysis binary and its dependencies without nogo.
+
+The toolchain depends on the binary the nogo build setting points to, so
+without this transition, the binary's own packages would need the binary
+to compile. Instrumentation and PGO are turned off, too: the binary runs
+at build time and doesn't need them.
+"""
+
+# Label of the setting changed by go_config_variant_transition, formatted
+# so it refers to this module even when loaded from another one.
+_CONFIG_VARIANT = str(Label("//internal:config_variant"))
+
+def _go_config_variant_transition_impl(settings, attr):
+    return {
+        "a": {_CONFIG_VARIANT: "a"},
+        "b": {_CONFIG_VARIANT: "b"},
+    }
+
+go_config_variant_transition = transition(
+    implementation = _go_config_variant_transition_impl,
+    inputs = [],
+    outputs = [_CONFIG_VARIANT],
+)
+"""Builds a target in two configurations that differ only in output directory.
+
+This is a split transition: the attribute it's attached to has two values,
+keyed by "a" and "b". Nothing reads the setting it changes, so the two
+configurations only differ in their hash, which Bazel includes in the output
+directory name.
+"""
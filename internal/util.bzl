@@ -5,8 +5,46 @@
 
 """Starlark utility functions, used in multiple .bzl files."""
 
+# PLATFORMS lists (goos, goarch) pairs for supported platforms. The go module
+# extension downloads a Go distribution for each of these, and each may be
+# a target platform for any of the others. The root package declares a
+# platform named goos_goarch for each.
+PLATFORMS = [
+    ("darwin", "arm64"),
+    ("linux", "amd64"),
+    ("linux", "arm64"),
+    ("windows", "amd64"),
+]
+
+# INSTRUMENTATION_PLATFORMS lists the platforms in PLATFORMS where each kind
+# of instrumentation is supported, matching where the go command allows
+# -race, -msan, and -asan.
+INSTRUMENTATION_PLATFORMS = {
+    "race": PLATFORMS,
+    "msan": [("linux", "amd64"), ("linux", "arm64")],
+    "asan": [("linux", "amd64"), ("linux", "arm64")],
+}
+
 def find_go_cmd(tools):
     for f in tools:
         if f.path.endswith("/bin/go") or f.path.endswith("/bin/go.exe"):
             return f
     fail("could not locate go tool")
+
+def check_sanitizer_compiler(label, sanitizer, cc_toolchain):
+    """Fails if the C/C++ toolchain can't build code with a sanitizer.
+
+    The memory sanitizer is only implemented by clang. With another compiler,
+    builds fail late with confusing errors from the compiler or linker.
+
+    Args:
+        label: label of the target being analyzed, used in the error message.
+        sanitizer: "msan", "asan", or empty.
+        cc_toolchain: the resolved CcToolchainInfo.
+    """
+    if sanitizer == "msan" and cc_toolchain.compiler != "clang":
+        fail("{}: the memory sanitizer requires clang, but the C compiler is {} ({}). Configure a clang C/C++ toolchain, for example by building with CC=clang.".format(
+            label,
+            cc_toolchain.compiler,
+            cc_toolchain.compiler_executable,
+        ))
@@ -0,0 +1,201 @@
+# Copyright Jay Conrod. All rights reserved.
+
+# This file is part of rules_go_simple. Use of this source code is governed by
+# the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+"""Analysis tests for Go rules.
+
+These tests check the actions a target generates without running them.
+"""
+
+load("@bazel_skylib//lib:unittest.bzl", "analysistest", "asserts")
+load("//internal:util.bzl", "PLATFORMS")
+
+def _package_archives(action, package):
+    """Returns short paths of .a and .x inputs of action built in package."""
+    return sorted([
+        f.short_path
+        for f in action.inputs.to_list()
+        if f.short_path.startswith(package + "/") and f.extension in ("a", "x")
+    ])
+
+def _find_action(env, mnemonic):
+    actions = [a for a in analysistest.target_actions(env) if a.mnemonic == mnemonic]
+    asserts.equals(env, 1, len(actions), "number of {} actions".format(mnemonic))
+    return actions[0] if actions else None
+
+def _export_inputs_test_impl(ctx):
+    env = analysistest.begin(ctx)
+    package = analysistest.target_under_test(env).label.package
+
+    # The main package is compiled against export data for its direct
+    # dependencies only.
+    compile = _find_action(env, "GoCompile")
+    if compile:
+        want = ["{}/{}.x".format(package, dep) for dep in ctx.attr.direct_deps]
+        asserts.equals(env, sorted(want), _package_archives(compile, package), "GoCompile archive inputs")
+
+    # The linker reads object code for every package in the binary.
+    link = _find_action(env, "GoLink")
+    if link:
+        want = ["{}/{}.a".format(package, dep) for dep in ctx.attr.linked]
+        asserts.equals(env, sorted(want), _package_archives(link, package), "GoLink archive inputs")
+
+    return analysistest.end(env)
+
+export_inputs_test = analysistest.make(
+    _export_inputs_test_impl,
+    attrs = {
+        "direct_deps": attr.string_list(
+            doc = "Names of libraries in the same package the binary imports",
+        ),
+        "linked": attr.string_list(
+            doc = "Names of targets in the same package linked into the binary",
+        ),
+    },
+)
+"""Checks which archives a go_binary's compile and link actions read.
+
+Compile actions must only read export data (.x files) for dependencies, and
+link actions must only read object code (.a files).
+"""
+
+_ResolvedToolchainInfo = provider(
+    doc = "Describes the Go toolchain resolved for a resolved_go_toolchain target.",
+    fields = {
+        "goos": "Target operating system the toolchain builds for",
+        "goarch": "Target architecture the toolchain builds for",
+        "builder": "Label of the target that produced the builder binary",
+        "stdlib": "Label of the go_stdlib target the toolchain uses",
+    },
+)
+
+def _toolchain_platforms_transition_impl(settings, attr):
+    return {
+        "//command_line_option:extra_execution_platforms": [str(Label("//:" + attr.exec_platform))],
+        "//command_line_option:platforms": str(Label("//:" + attr.target_platform)),
+    }
+
+_toolchain_platforms_transition = transition(
+    implementation = _toolchain_platforms_transition_impl,
+    inputs = [],
+    outputs = [
+        "//command_line_option:extra_execution_platforms",
+        "//command_line_option:platforms",
+    ],
+)
+
+def _resolved_go_toolchain_impl(ctx):
+    internal = ctx.toolchains["@rules_go_simple//:toolchain_type"].internal
+    return [_ResolvedToolchainInfo(
+        goos = internal.goos,
+        goarch = internal.goarch,
+        builder = internal.builder.owner,
+        stdlib = internal.stdlib.pkgs.to_list()[0].owner,
+    )]
+
+resolved_go_toolchain = rule(
+    implementation = _resolved_go_toolchain_impl,
+    attrs = {
+        "exec_platform": attr.string(
+            mandatory = True,
+            doc = "Name of a platform in the root package to build on, like linux_amd64",
+        ),
+        "target_platform": attr.string(
+            mandatory = True,
+            doc = "Name of a platform in the root package to build for",
+        ),
+    },
+    cfg = _toolchain_platforms_transition,
+    toolchains = ["@rules_go_simple//:toolchain_type"],
+)
+"""Resolves the Go toolchain for an execution and target platform.
+
+The execution platform is added with --extra_execution_platforms, so it's
+preferred over the host platform. Nothing is built.
+"""
+
+def _toolchain_resolution_test_impl(ctx):
+    env = analysistest.begin(ctx)
+    info = analysistest.target_under_test(env)[_ResolvedToolchainInfo]
+
+    # The toolchain builds for the target platform.
+    asserts.equals(env, ctx.attr.target_platform, "{}_{}".format(info.goos, info.goarch), "toolchain goos_goarch")
+
+    # The builder and standard library both come from the distribution for
+    # the execution platform, which compiles the standard library for the
+    # target platform.
+    exec_repo = "go_" + ctx.attr.exec_platform
+    for name, label in [("builder", info.builder), ("stdlib", info.stdlib)]:
+        asserts.true(
+            env,
+            label.repo_name.endswith(exec_repo),
+            "{} is {}; want a target in {}".format(name, label, exec_repo),
+        )
+    asserts.equals(env, "stdlib_" + ctx.attr.target_platform, info.stdlib.name, "stdlib target name")
+
+    return analysistest.end(env)
+
+toolchain_resolution_test = analysistest.make(
+    _toolchain_resolution_test_impl,
+    attrs = {
+        "exec_platform": attr.string(mandatory = True),
+        "target_platform": attr.string(mandatory = True),
+    },
+)
+"""Checks which toolchain is resolved for a resolved_go_toolchain target."""
+
+def toolchain_resolution_test_suite(name):
+    """Declares a toolchain_resolution_test for each pair of PLATFORMS.
+
+    Args:
+        name: name of the test_suite containing the tests. Tests and the
+            targets they check are named with this as a prefix.
+    """
+    tests = []
+    for exec_goos, exec_goarch in PLATFORMS:
+        for goos, goarch in PLATFORMS:
+            exec_platform = "{}_{}".format(exec_goos, exec_goarch)
+            target_platform = "{}_{}".format(goos, goarch)
+            test_name = "{}_{}_to_{}".format(name, exec_platform, target_platform)
+            resolved_go_toolchain(
+                name = test_name + "_toolchain",
+                exec_platform = exec_platform,
+                target_platform = target_platform,
+                tags = ["manual"],
+            )
+            toolchain_resolution_test(
+                name = test_name,
+                exec_platform = exec_platform,
+                target_platform = target_platform,
+                target_under_test = ":{}_toolchain".format(test_name),
+            )
+            tests.append(test_name)
+    native.test_suite(
+        name = name,
+        tests = tests,
+    )
+
+def _msan_unsupported_test_impl(ctx):
+    env = analysistest.begin(ctx)
+    asserts.expect_failure(env, "msan is not supported on " + ctx.attr.platform)
+    return analysistest.end(env)
+
+msan_unsupported_test = analysistest.make(
+    _msan_unsupported_test_impl,
+    attrs = {
+        "platform": attr.string(
+            mandatory = True,
+            doc = "goos/goarch of the target platform, as it appears in the error",
+        ),
+    },
+    config_settings = {
+        str(Label("//:sanitizer")): "msan",
+    },
+    expect_failure = True,
+)
+"""Checks that go_toolchain rejects the memory sanitizer on a platform.
+
+target_under_test should be a resolved_go_toolchain for a platform where the
+go command doesn't allow -msan.
+"""
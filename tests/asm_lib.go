@@ -0,0 +1,17 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package asm_lib
+
+// pair is passed to assembly. Its field offsets are available to assembly
+// files through go_asm.h.
+type pair struct {
+	a, b int64
+}
+
+// Sum returns a + b.
+func Sum(a, b int64) int64 {
+	return sum(&pair{a, b})
+}
@@ -0,0 +1,15 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+#include "go_asm.h"
+#include "textflag.h"
+
+// func sum(p *pair) int64
+TEXT ·sum(SB), NOSPLIT, $0-16
+	MOVQ p+0(FP), AX
+	MOVQ pair_a(AX), BX
+	ADDQ pair_b(AX), BX
+	MOVQ BX, ret+8(FP)
+	RET
@@ -0,0 +1,16 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+#include "go_asm.h"
+#include "textflag.h"
+
+// func sum(p *pair) int64
+TEXT ·sum(SB), NOSPLIT, $0-16
+	MOVD p+0(FP), R0
+	MOVD pair_a(R0), R1
+	MOVD pair_b(R0), R2
+	ADD R1, R2, R1
+	MOVD R1, ret+8(FP)
+	RET
@@ -0,0 +1,11 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+//go:build amd64 || arm64
+
+package asm_lib
+
+// sum is implemented in assembly.
+func sum(p *pair) int64
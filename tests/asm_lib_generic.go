@@ -0,0 +1,12 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+//go:build !amd64 && !arm64
+
+package asm_lib
+
+func sum(p *pair) int64 {
+	return p.a + p.b
+}
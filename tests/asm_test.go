@@ -0,0 +1,24 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package asm_test
+
+import (
+	"rules_go_simple/tests/asm_lib"
+	"rules_go_simple/tests/asm_pp_lib"
+	"testing"
+)
+
+func TestSum(t *testing.T) {
+	if got := asm_lib.Sum(2, 3); got != 5 {
+		t.Errorf("got %d; want 5", got)
+	}
+}
+
+func TestDouble(t *testing.T) {
+	if got := asm_pp_lib.Double(21); got != 42 {
+		t.Errorf("got %d; want 42", got)
+	}
+}
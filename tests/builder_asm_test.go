@@ -0,0 +1,42 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestArchFeatureDefines(t *testing.T) {
+	for _, tc := range []struct {
+		goarch, key, value string
+		want               []string
+	}{
+		{goarch: "amd64", want: []string{"-D", "GOAMD64_v1"}},
+		{goarch: "amd64", key: "GOAMD64", value: "v3", want: []string{"-D", "GOAMD64_v3"}},
+		{goarch: "arm", want: []string{"-D", "GOARM_7", "-D", "GOARM_6", "-D", "GOARM_5"}},
+		{goarch: "arm", key: "GOARM", value: "6,softfloat", want: []string{"-D", "GOARM_6", "-D", "GOARM_5"}},
+		{goarch: "arm", key: "GOARM", value: "5", want: []string{"-D", "GOARM_5"}},
+		{goarch: "arm64"},
+		{goarch: "arm64", key: "GOARM64", value: "v8.0"},
+		{goarch: "arm64", key: "GOARM64", value: "v8.0,lse", want: []string{"-D", "GOARM64_LSE"}},
+		{goarch: "arm64", key: "GOARM64", value: "v8.1", want: []string{"-D", "GOARM64_LSE"}},
+		{goarch: "arm64", key: "GOARM64", value: "v9.0,crypto", want: []string{"-D", "GOARM64_LSE"}},
+		{goarch: "ppc64le", key: "GOPPC64", value: "power9", want: []string{"-D", "GOPPC64_power9", "-D", "GOPPC64_power8"}},
+		{goarch: "riscv64", want: []string{"-D", "GORISCV64_rva20u64"}},
+		{goarch: "wasm"},
+	} {
+		for _, key := range []string{"GO386", "GOAMD64", "GOARM", "GOARM64", "GOPPC64", "GORISCV64"} {
+			t.Setenv(key, "")
+		}
+		if tc.key != "" {
+			t.Setenv(tc.key, tc.value)
+		}
+		if got := archFeatureDefines(tc.goarch); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("archFeatureDefines(%q) with %s=%q: got %q; want %q", tc.goarch, tc.key, tc.value, got, tc.want)
+		}
+	}
+}
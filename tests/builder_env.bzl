@@ -0,0 +1,50 @@
+# Copyright Jay Conrod. All rights reserved.
+
+# This file is part of rules_go_simple. Use of this source code is governed by
+# the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+"""Support for testing the builder with the tools in the Go toolchain.
+
+builder_env writes a JSON file describing the toolchain's tools and compiled
+standard library, and makes those files available as runfiles. builder_test
+reads the file so it can run builder verbs like compile and test the way
+actions do, including on sources that must fail to build.
+
+This reads the toolchain's internal data, which rules outside
+rules_go_simple must not do.
+"""
+
+def _builder_env_impl(ctx):
+    internal = ctx.toolchains["@rules_go_simple//:toolchain_type"].internal
+
+    # Paths are written relative to the runfiles directory of the main
+    # repository, like rootpath paths. Files in other repositories start
+    # with "../".
+    tools = {name: f.short_path for name, f in internal.tools.items()}
+    pkgs = internal.stdlib.pkgs.to_list()
+    pkg_rel = pkgs[0].path[len(internal.stdlib.root):]
+    stdlib = pkgs[0].short_path[:-len(pkg_rel)]
+
+    # Tools are in GOROOT/pkg/tool/GOOS_GOARCH.
+    compile = internal.tools["compile"].short_path
+    goroot = compile[:compile.rindex("/pkg/tool/")]
+
+    out = ctx.actions.declare_file(ctx.label.name + ".json")
+    ctx.actions.write(out, json.encode({
+        "goos": internal.goos,
+        "goarch": internal.goarch,
+        "goroot": goroot,
+        "stdlib": stdlib,
+        "tools": tools,
+    }))
+    runfiles = ctx.runfiles(
+        files = [out] + internal.tools.values() + internal.asm_headers,
+        transitive_files = internal.stdlib.pkgs,
+    )
+    return [DefaultInfo(files = depset([out]), runfiles = runfiles)]
+
+builder_env = rule(
+    implementation = _builder_env_impl,
+    doc = "Describes the Go toolchain's tools and standard library in a JSON file",
+    toolchains = ["@rules_go_simple//:toolchain_type"],
+)
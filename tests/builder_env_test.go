@@ -0,0 +1,107 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var builderEnvPath = flag.String("builderenv", "", "path to a JSON file written by builder_env describing the toolchain's tools")
+
+// builderEnv holds paths to the toolchain's tools and standard library, so
+// tests can run builder verbs the way actions do.
+type builderEnv struct {
+	GOOS, GOARCH, GOROOT string
+	Stdlib               string
+	Tools                map[string]string
+
+	stdImportcfgPath string
+}
+
+// loadBuilderEnv reads the file written by builder_env and generates
+// an importcfg for the standard library.
+func loadBuilderEnv(t *testing.T) *builderEnv {
+	t.Helper()
+	data, err := os.ReadFile(runfilePath(*builderEnvPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env builderEnv
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	env.Stdlib = runfilePath(env.Stdlib)
+	for name, path := range env.Tools {
+		env.Tools[name] = runfilePath(path)
+	}
+	goroot, err := filepath.Abs(runfilePath(env.GOROOT))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOROOT", goroot)
+
+	env.stdImportcfgPath = filepath.Join(t.TempDir(), "std.importcfg")
+	if out, err := runBuilder("stdimportcfg", "-stdlib", env.Stdlib, "-o", env.stdImportcfgPath); err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	return &env
+}
+
+// run runs a builder verb like compile or test with the toolchain's tools
+// and standard library. It returns the builder's output and an error if
+// the builder failed.
+func (env *builderEnv) run(verb string, args ...string) (string, error) {
+	fullArgs := []string{verb, "-stdimportcfg", env.stdImportcfgPath, "-goos", env.GOOS, "-goarch", env.GOARCH}
+	for _, name := range sortedKeys(env.Tools) {
+		fullArgs = append(fullArgs, "-tool", name+"="+env.Tools[name])
+	}
+	return runBuilder(append(fullArgs, args...)...)
+}
+
+func runBuilder(args ...string) (string, error) {
+	out := &bytes.Buffer{}
+	r := &request{stdout: out, stderr: out}
+	if code := run(r, args); code != 0 {
+		return out.String(), fmt.Errorf("builder %s exited with code %d", args[0], code)
+	}
+	return out.String(), nil
+}
+
+// runfilePath converts a path relative to the runfiles directory of the main
+// repository, like a rootpath, to a path relative to the package directory,
+// where the test runs.
+func runfilePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	rel, err := filepath.Rel("tests", filepath.FromSlash(path))
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// writeFiles writes files with the given names and contents into a new
+// temporary directory and returns their paths, sorted by name.
+func writeFiles(t *testing.T, files map[string]string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range sortedKeys(files) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(files[name]), 0666); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
@@ -0,0 +1,87 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestCompileExportOut checks that with -exportout, the compiler's export
+// data and object code are written to separate archives, and that packages
+// importing the library compile against the export data while the linker
+// uses the object code.
+func TestCompileExportOut(t *testing.T) {
+	env := loadBuilderEnv(t)
+	srcs := writeFiles(t, map[string]string{
+		"lib.go":  "package lib\n\nfunc Message() string { return \"hello\" }\n",
+		"main.go": "package main\n\nimport \"example.com/lib\"\n\nfunc main() { println(lib.Message()) }\n",
+	})
+	dir := t.TempDir()
+	libArchive := filepath.Join(dir, "lib.a")
+	libExport := filepath.Join(dir, "lib.x")
+	if output, err := env.run("compile", "-p", "example.com/lib", "-o", libArchive, "-exportout", libExport, srcs[0]); err != nil {
+		t.Fatalf("%v:\n%s", err, output)
+	}
+	for _, tc := range []struct {
+		path string
+		want []string
+	}{
+		{libExport, []string{"__.PKGDEF"}},
+		{libArchive, []string{"_go_.o"}},
+	} {
+		if got := archiveMembers(t, tc.path); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("members of %s: got %q; want %q", filepath.Base(tc.path), got, tc.want)
+		}
+	}
+
+	// The linker can't read export data, and the compiler can't read object
+	// code, so building a binary only works if each gets the right file.
+	mainArchive := filepath.Join(dir, "main.a")
+	if output, err := env.run("compile", "-p", "main", "-arc", "example.com/lib="+libExport, "-o", mainArchive, srcs[1]); err != nil {
+		t.Fatalf("%v:\n%s", err, output)
+	}
+	if output, err := env.run("link", "-arc", "example.com/lib="+libArchive, "-main", mainArchive, "-o", filepath.Join(dir, "main")); err != nil {
+		t.Fatalf("%v:\n%s", err, output)
+	}
+}
+
+// archiveMembers returns the names of the members of an archive file.
+func archiveMembers(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != arMagic {
+		t.Fatalf("%s: not an archive", path)
+	}
+	var names []string
+	header := make([]byte, arHeaderSize)
+	for {
+		if _, err := io.ReadFull(f, header); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, strings.TrimSpace(string(header[:16])))
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Seek(size+size%2, io.SeekCurrent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return names
+}
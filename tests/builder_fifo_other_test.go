@@ -0,0 +1,14 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+//go:build !unix
+
+package main
+
+import "testing"
+
+func makeFIFO(t *testing.T, path string) {
+	t.Skip("named pipes are not supported on this platform")
+}
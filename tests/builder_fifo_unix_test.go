@@ -0,0 +1,21 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+//go:build unix
+
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+// makeFIFO creates a named pipe at path. Opening it for reading blocks until
+// something opens it for writing.
+func makeFIFO(t *testing.T, path string) {
+	if err := syscall.Mkfifo(path, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMissingDepError(t *testing.T) {
+	depLabels := map[string]string{
+		"example.com/foo": "//foo:foo",
+		"example.com/bar": "@@rules_go_simple+//bar:bar",
+	}
+	for _, tc := range []struct {
+		imp, label, want string
+	}{
+		{
+			imp:   "example.com/foo",
+			label: "//main:main",
+			want:  `main.go: import "example.com/foo" is not provided by any direct dependency: add //foo:foo to deps of //main:main`,
+		}, {
+			imp:   "example.com/bar",
+			label: "//main:main",
+			want:  `main.go: import "example.com/bar" is not provided by any direct dependency: add @@rules_go_simple+//bar:bar to deps of //main:main`,
+		}, {
+			imp:  "example.com/foo",
+			want: `main.go: import "example.com/foo" is not provided by any direct dependency: add //foo:foo to deps of this target`,
+		}, {
+			imp:   "example.com/baz",
+			label: "//main:main",
+			want:  `main.go: import "example.com/baz" is not provided by any direct dependency`,
+		},
+	} {
+		err := missingDepError("main.go", tc.imp, depLabels, tc.label)
+		if got := err.Error(); got != tc.want {
+			t.Errorf("missingDepError(%q, %q):\ngot  %s\nwant %s", tc.imp, tc.label, got, tc.want)
+		}
+	}
+}
+
+// TestStdImportcfg checks that the stdimportcfg verb lists compiled packages
+// in a go_stdlib directory with paths relative to the working directory.
+// Empty files are placeholders for packages that weren't compiled.
+func TestStdImportcfg(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for name, content := range map[string]string{
+		"stdlib/fmt.a":          "!<arch>\n",
+		"stdlib/internal/abi.a": "!<arch>\n",
+		"stdlib/net/http.a":     "!<arch>\n",
+		"stdlib/plugin.a":       "",
+		"stdlib/README":         "not a package",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out, err := runBuilder("stdimportcfg", "-stdlib", "./stdlib/", "-o", "std.importcfg"); err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	got, err := os.ReadFile("std.importcfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "packagefile fmt=stdlib/fmt.a\n" +
+		"packagefile internal/abi=stdlib/internal/abi.a\n" +
+		"packagefile net/http=stdlib/net/http.a\n"
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := runBuilder("stdimportcfg", "-stdlib", "stdlib", "-o", "std.importcfg", "extra"); err == nil {
+		t.Error("got nil error with positional argument; want error")
+	}
+}
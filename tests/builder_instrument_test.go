@@ -0,0 +1,69 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestInstrumentationFlags(t *testing.T) {
+	for _, tc := range []struct {
+		args                                []string
+		wantTags, wantToolFlags, wantCFlags []string
+	}{
+		{
+			args: nil,
+		}, {
+			args:          []string{"-race"},
+			wantTags:      []string{"race"},
+			wantToolFlags: []string{"-race"},
+		}, {
+			args:          []string{"-sanitizer", "msan"},
+			wantTags:      []string{"msan"},
+			wantToolFlags: []string{"-msan"},
+			wantCFlags:    []string{"-fsanitize=memory"},
+		}, {
+			args:          []string{"-sanitizer", "asan"},
+			wantTags:      []string{"asan"},
+			wantToolFlags: []string{"-asan"},
+			wantCFlags:    []string{"-fsanitize=address"},
+		},
+	} {
+		var in instrumentation
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		in.registerFlags(fs)
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := in.check(); err != nil {
+			t.Errorf("%q: check: %v", tc.args, err)
+			continue
+		}
+		if got := in.tags(); !reflect.DeepEqual(got, tc.wantTags) {
+			t.Errorf("%q: tags: got %q; want %q", tc.args, got, tc.wantTags)
+		}
+		if got := in.toolFlags(); !reflect.DeepEqual(got, tc.wantToolFlags) {
+			t.Errorf("%q: toolFlags: got %q; want %q", tc.args, got, tc.wantToolFlags)
+		}
+		if got := in.cFlags(); !reflect.DeepEqual(got, tc.wantCFlags) {
+			t.Errorf("%q: cFlags: got %q; want %q", tc.args, got, tc.wantCFlags)
+		}
+	}
+}
+
+func TestInstrumentationCheckErrors(t *testing.T) {
+	for _, in := range []instrumentation{
+		{sanitizer: "tsan"},
+		{race: true, sanitizer: "msan"},
+		{race: true, sanitizer: "asan"},
+	} {
+		if err := in.check(); err == nil {
+			t.Errorf("%+v: check: got nil error; want error", in)
+		}
+	}
+}
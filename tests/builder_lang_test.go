@@ -0,0 +1,64 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLangVersionGoMod(t *testing.T) {
+	for _, tc := range []struct {
+		name, gomod, want string
+		wantErr           bool
+	}{
+		{
+			name:  "directive",
+			gomod: "module example.com/m\n\ngo 1.21.3\n",
+			want:  "go1.21",
+		}, {
+			name:  "comment",
+			gomod: "module example.com/m // go 1.19\n\ngo 1.22 // not 1.23\n",
+			want:  "go1.22",
+		}, {
+			// The go command assumes go 1.16 for modules without a go
+			// directive.
+			name:  "no_directive",
+			gomod: "module example.com/m\n",
+			want:  "go1.16",
+		}, {
+			name:    "malformed",
+			gomod:   "module example.com/m\n\ngo 1.21 1.22\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "go.mod")
+			if err := os.WriteFile(path, []byte(tc.gomod), 0666); err != nil {
+				t.Fatal(err)
+			}
+			got, err := langVersion("", path)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("got %q; want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+
+	// A version set on the target takes precedence over go.mod.
+	if got, err := langVersion("1.20", "nonexistent.mod"); err != nil || got != "go1.20" {
+		t.Errorf("got %q, %v; want \"go1.20\"", got, err)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import "testing"
+
+func TestQuoteFlags(t *testing.T) {
+	for _, tc := range []struct {
+		flags []string
+		want  string
+	}{
+		{flags: []string{"-lfoo", "-L/lib"}, want: "-lfoo -L/lib"},
+		{flags: []string{"-DMSG=a b"}, want: "'-DMSG=a b'"},
+		{flags: []string{`-DMSG="a"`}, want: `'-DMSG="a"'`},
+		{flags: []string{"-DMSG='a'"}, want: `"-DMSG='a'"`},
+	} {
+		got, err := quoteFlags(tc.flags)
+		if err != nil {
+			t.Errorf("quoteFlags(%q): %v", tc.flags, err)
+		} else if got != tc.want {
+			t.Errorf("quoteFlags(%q): got %q; want %q", tc.flags, got, tc.want)
+		}
+	}
+
+	if _, err := quoteFlags([]string{`-DMSG='a' "b"`}); err == nil {
+		t.Error("quoteFlags with both quote characters: got nil error; want error")
+	}
+}
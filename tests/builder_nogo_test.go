@@ -0,0 +1,163 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var nogoPath = flag.String("nogo", "", "path to a nogo binary that reports calls to functions named Forbidden")
+
+func TestNogoAllowlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{
+	"only": {"only_files": {"^src/": "only our code", "_gen\\.go$": "and generated code"}},
+	"exclude": {"exclude_files": {"^third_party/": "not our code"}},
+	"both": {
+		"only_files": {"^src/": "only our code"},
+		"exclude_files": {"_test\\.go$": "not tests"}
+	}
+}`
+	if err := os.WriteFile(path, []byte(config), 0666); err != nil {
+		t.Fatal(err)
+	}
+	allowlist, err := readNogoAllowlist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		analyzer, path string
+		want           bool
+	}{
+		{"only", "src/a.go", true},
+		{"only", "lib/a_gen.go", true},
+		{"only", "lib/a.go", false},
+		{"only", "lib/src/a.go", false},
+		{"exclude", "third_party/a.go", false},
+		{"exclude", "src/third_party/a.go", true},
+		{"both", "src/a.go", true},
+		{"both", "src/a_test.go", false},
+		{"both", "lib/a.go", false},
+		{"unlisted", "third_party/a.go", true},
+	} {
+		if got := allowlist.allows(tc.analyzer, tc.path); got != tc.want {
+			t.Errorf("allows(%q, %q) = %t; want %t", tc.analyzer, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestNogoAllowlistErrors(t *testing.T) {
+	if allowlist, err := readNogoAllowlist(""); err != nil || !allowlist.allows("any", "a.go") {
+		t.Errorf("empty path: got %v, %v; want allowlist that allows everything", allowlist, err)
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"printf": {"only_files": {"(": "bad"}}}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readNogoAllowlist(path); err == nil || !strings.Contains(err.Error(), "analyzer printf") {
+		t.Errorf("got error %v; want error about analyzer printf", err)
+	}
+}
+
+func TestPosnFile(t *testing.T) {
+	for _, tc := range []struct{ posn, want string }{
+		{"a/b.go:12:3", "a/b.go"},
+		{"a/b.go:12", "a/b.go"},
+		{"a/b.go", "a/b.go"},
+		{`C:\a\b.go:12:3`, `C:\a\b.go`},
+		{"a/b:c.go:1:2", "a/b:c.go"},
+		{"a/b.go:x:2", "a/b.go:x"},
+		{"", ""},
+	} {
+		if got := posnFile(tc.posn); got != tc.want {
+			t.Errorf("posnFile(%q) = %q; want %q", tc.posn, got, tc.want)
+		}
+	}
+}
+
+// TestNogoCompile checks that findings from a nogo binary built by the nogo
+// rule fail compilation, unless the allowlist excludes them.
+func TestNogoCompile(t *testing.T) {
+	env := loadBuilderEnv(t)
+	nogo := runfilePath(*nogoPath)
+	srcs := writeFiles(t, map[string]string{
+		"bad.go": "package bad\n\nfunc Forbidden() {}\n\nfunc F() { Forbidden() }\n",
+	})
+	out := filepath.Join(t.TempDir(), "bad.a")
+	args := []string{"-p", "example.com/bad", "-nogo", nogo, "-o", out}
+
+	output, err := env.run("compile", append(args, srcs...)...)
+	if err == nil {
+		t.Fatal("compile succeeded; want nogo finding")
+	}
+	if !strings.Contains(output, "nogo found problems") || !strings.Contains(output, "bad.go:5:12: call to Forbidden (forbidden)") {
+		t.Errorf("got output:\n%s\nwant nogo finding in bad.go", output)
+	}
+
+	config := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(config, []byte(`{"forbidden": {"exclude_files": {"/bad\\.go$": "allowed"}}}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := env.run("compile", append(append(args, "-nogoconfig", config), srcs...)...); err != nil {
+		t.Errorf("compile with allowlist: %v\n%s", err, output)
+	}
+}
+
+// TestNogoTestArchives checks that nogo runs on both the internal and
+// external test archives built by the test verb.
+func TestNogoTestArchives(t *testing.T) {
+	env := loadBuilderEnv(t)
+	nogo := runfilePath(*nogoPath)
+	for _, tc := range []struct {
+		name, badFile string
+		files         map[string]string
+	}{
+		{
+			name: "ok",
+			files: map[string]string{
+				"lib_test.go":   "package lib\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n",
+				"lib_x_test.go": "package lib_test\n\nimport \"testing\"\n\nfunc TestB(t *testing.T) {}\n",
+			},
+		}, {
+			name:    "internal",
+			badFile: "lib_test.go",
+			files: map[string]string{
+				"lib_test.go":   "package lib\n\nimport \"testing\"\n\nfunc Forbidden() {}\n\nfunc TestA(t *testing.T) { Forbidden() }\n",
+				"lib_x_test.go": "package lib_test\n\nimport \"testing\"\n\nfunc TestB(t *testing.T) {}\n",
+			},
+		}, {
+			name:    "external",
+			badFile: "lib_x_test.go",
+			files: map[string]string{
+				"lib_test.go":   "package lib\n\nimport \"testing\"\n\nfunc Forbidden() {}\n\nfunc TestA(t *testing.T) {}\n",
+				"lib_x_test.go": "package lib_test\n\nimport (\n\t\"example.com/lib\"\n\t\"testing\"\n)\n\nfunc TestB(t *testing.T) { lib.Forbidden() }\n",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srcs := writeFiles(t, tc.files)
+			out := filepath.Join(t.TempDir(), "lib_test")
+			args := append([]string{"-p", "example.com/lib", "-vet", "off", "-nogo", nogo, "-o", out}, srcs...)
+			output, err := env.run("test", args...)
+			if tc.badFile == "" {
+				if err != nil {
+					t.Fatalf("%v:\n%s", err, output)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("test succeeded; want nogo finding in %s", tc.badFile)
+			}
+			if !strings.Contains(output, tc.badFile+":") || !strings.Contains(output, "call to Forbidden (forbidden)") {
+				t.Errorf("got output:\n%s\nwant nogo finding in %s", output, tc.badFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindUnusedDeps(t *testing.T) {
+	archives := []archive{
+		{importPath: "example.com/foo"},
+		{importPath: "example.com/bar"},
+		{importPath: "example.com/baz"},
+		{importPath: "example.com/bar"},
+	}
+	used := map[string]bool{"example.com/foo": true}
+	depLabels := map[string]string{"example.com/bar": "//bar:bar"}
+	got := findUnusedDeps(archives, used, depLabels)
+	want := []unusedDep{
+		{ImportPath: "example.com/bar", Label: "//bar:bar"},
+		{ImportPath: "example.com/baz"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestReportUnusedDeps(t *testing.T) {
+	unused := []unusedDep{
+		{ImportPath: "example.com/bar", Label: "//bar:bar"},
+		{ImportPath: "example.com/baz"},
+	}
+	const msg = `direct dependencies of //main:main are not imported by any source: //bar:bar ("example.com/bar"), "example.com/baz"`
+
+	t.Run("off", func(t *testing.T) {
+		stderr := &bytes.Buffer{}
+		r := &request{stderr: stderr}
+		if err := reportUnusedDeps(r, "off", "", "//main:main", unused); err != nil {
+			t.Fatal(err)
+		}
+		if stderr.Len() != 0 {
+			t.Errorf("got output %q; want none", stderr)
+		}
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		stderr := &bytes.Buffer{}
+		r := &request{stderr: stderr}
+		if err := reportUnusedDeps(r, "warn", "", "//main:main", unused); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := stderr.String(), "compile: warning: "+msg+"\n"; got != want {
+			t.Errorf("got output %q; want %q", got, want)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		stderr := &bytes.Buffer{}
+		r := &request{stderr: stderr}
+		err := reportUnusedDeps(r, "error", "", "//main:main", unused)
+		if err == nil {
+			t.Fatal("got nil error; want error")
+		}
+		if err.Error() != msg {
+			t.Errorf("got error %q; want %q", err, msg)
+		}
+		if stderr.Len() != 0 {
+			t.Errorf("got output %q; want none", stderr)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		outPath := filepath.Join(t.TempDir(), "unused.json")
+		r := &request{stderr: &bytes.Buffer{}}
+		if err := reportUnusedDeps(r, "off", outPath, "//main:main", unused); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `{
+  "label": "//main:main",
+  "unused": [
+    {
+      "importpath": "example.com/bar",
+      "label": "//bar:bar"
+    },
+    {
+      "importpath": "example.com/baz"
+    }
+  ]
+}
+`
+		if string(got) != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("json_empty", func(t *testing.T) {
+		outPath := filepath.Join(t.TempDir(), "unused.json")
+		r := &request{stderr: &bytes.Buffer{}}
+		if err := reportUnusedDeps(r, "error", outPath, "", nil); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "{\n  \"unused\": []\n}\n"; string(got) != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var (
+	fooArchivePath      = flag.String("fooarchive", "", "path to the archive compiled by the foo go_library")
+	fooUnusedInputsPath = flag.String("foounusedinputs", "", "path to the unused inputs list written when compiling foo")
+)
+
+// TestGoObjectMagic checks that the compiler the toolchain uses writes object
+// files in the format readGoObjectImports reads. When the Go version in
+// MODULE.bazel is upgraded and this fails, readGoObjectImports must be
+// updated to read the new format.
+func TestGoObjectMagic(t *testing.T) {
+	path := strings.TrimPrefix(*fooArchivePath, "tests/")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(goobjMagic)) {
+		t.Fatalf("%s does not contain object file magic %q; the object file format may have changed", path, goobjMagic)
+	}
+}
+
+// TestReadArchiveImports checks that imports can be read from the Go object
+// file in an archive written by the compiler the toolchain uses. If the
+// object file format changes, this fails, and unused inputs are no longer
+// reported.
+func TestReadArchiveImports(t *testing.T) {
+	path := strings.TrimPrefix(*fooArchivePath, "tests/")
+	imports, err := readArchiveImports(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"fmt", "rules_go_simple/tests/bar", "rules_go_simple/tests/baz"} {
+		if !slices.Contains(imports, want) {
+			t.Errorf("imports of %s: got %q; want %q to be included", path, imports, want)
+		}
+	}
+}
+
+// TestFindLinkedArchives checks that archives foo imports, directly or
+// through the standard library, are found, and that an archive nothing
+// imports isn't.
+func TestFindLinkedArchives(t *testing.T) {
+	path := strings.TrimPrefix(*fooArchivePath, "tests/")
+	archiveMap := map[string]string{
+		"rules_go_simple/tests/foo": path,
+		"unrelated":                 "unrelated.a",
+	}
+	used, err := findLinkedArchives(path, archiveMap, instrumentation{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !used[path] {
+		t.Errorf("used does not contain main archive %s", path)
+	}
+	if used["unrelated.a"] {
+		t.Errorf("used contains unrelated.a, which nothing imports")
+	}
+}
+
+// TestCompileUnusedInputs checks the list of archives the compile action for
+// foo didn't read. Bazel doesn't rerun the action when listed files change,
+// so standard library packages foo doesn't import must be listed, and
+// packages it does import must not be.
+func TestCompileUnusedInputs(t *testing.T) {
+	data, err := os.ReadFile(strings.TrimPrefix(*fooUnusedInputsPath, "tests/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unused := strings.Fields(string(data))
+	hasSuffix := func(suffix string) bool {
+		return slices.ContainsFunc(unused, func(path string) bool {
+			return strings.HasSuffix(path, suffix)
+		})
+	}
+	if !hasSuffix("/net/http.a") {
+		t.Errorf("unused inputs do not include net/http.a, which foo doesn't import:\n%s", data)
+	}
+	for _, suffix := range []string{"/fmt.a", "/bar.x", "/baz.x"} {
+		if hasSuffix(suffix) {
+			t.Errorf("unused inputs include %s, which foo imports:\n%s", suffix[1:], data)
+		}
+	}
+}
+
+func TestReadGoObjectImportsUnknownFormat(t *testing.T) {
+	if _, err := readGoObjectImports([]byte("go object linux amd64 go1.0\n!\n\x00go999ld")); err == nil {
+		t.Error("got nil error; want error")
+	}
+}
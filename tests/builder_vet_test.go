@@ -0,0 +1,133 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadVetFindings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json")
+	data := `{
+	"example.com/lib": {
+		"printf": [
+			{"posn": "lib/b.go:3:2", "message": "bad verb"},
+			{"posn": "third_party/c.go:1:1", "message": "bad verb"}
+		],
+		"tests": [{"posn": "lib/a_test.go:5:1", "message": "malformed test name"}],
+		"nilness": {"error": "type checking failed"}
+	}
+}`
+	if err := os.WriteFile(path, []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readVetFindings(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"analyzer nilness failed: type checking failed",
+		"lib/a_test.go:5:1: malformed test name (tests)",
+		"lib/b.go:3:2: bad verb (printf)",
+		"third_party/c.go:1:1: bad verb (printf)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	allows := func(analyzer, path string) bool {
+		return !strings.HasPrefix(path, "third_party/")
+	}
+	got, err = readVetFindings(path, allows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := want[:3]; !reflect.DeepEqual(got, want) {
+		t.Errorf("with allows: got %q; want %q", got, want)
+	}
+}
+
+func TestReadVetFindingsEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json")
+	if err := os.WriteFile(path, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readVetFindings(path, nil); err != nil || len(got) != 0 {
+		t.Errorf("got %q, %v; want no findings", got, err)
+	}
+	if err := os.WriteFile(path, []byte(`{"example.com/lib": {"printf": 1}}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readVetFindings(path, nil); err == nil {
+		t.Error("got nil error for malformed result; want error")
+	}
+}
+
+// TestVetTest checks that vet findings in test sources fail the test verb,
+// and that facts about the internal test package, like which functions are
+// printf wrappers, are used when vetting the external test package.
+func TestVetTest(t *testing.T) {
+	env := loadBuilderEnv(t)
+	const exportTest = "package lib\n\nimport (\n\t\"fmt\"\n\t\"testing\"\n)\n\nfunc Logf(format string, args ...any) { fmt.Printf(format, args...) }\n\nfunc TestA(t *testing.T) {}\n"
+	for _, tc := range []struct {
+		name, vet, badFile string
+		files              map[string]string
+	}{
+		{
+			name:    "internal",
+			badFile: "lib_test.go",
+			files: map[string]string{
+				"lib_test.go": "package lib\n\nimport (\n\t\"fmt\"\n\t\"testing\"\n)\n\nfunc TestA(t *testing.T) { fmt.Printf(\"%d\\n\", \"x\") }\n",
+			},
+		}, {
+			name: "off",
+			vet:  "off",
+			files: map[string]string{
+				"lib_test.go": "package lib\n\nimport (\n\t\"fmt\"\n\t\"testing\"\n)\n\nfunc TestA(t *testing.T) { fmt.Printf(\"%d\\n\", \"x\") }\n",
+			},
+		}, {
+			name: "facts_ok",
+			files: map[string]string{
+				"export_test.go": exportTest,
+				"lib_x_test.go":  "package lib_test\n\nimport (\n\t\"example.com/lib\"\n\t\"testing\"\n)\n\nfunc TestB(t *testing.T) { lib.Logf(\"%s\\n\", \"x\") }\n",
+			},
+		}, {
+			name:    "facts",
+			badFile: "lib_x_test.go",
+			files: map[string]string{
+				"export_test.go": exportTest,
+				"lib_x_test.go":  "package lib_test\n\nimport (\n\t\"example.com/lib\"\n\t\"testing\"\n)\n\nfunc TestB(t *testing.T) { lib.Logf(\"%d\\n\", \"x\") }\n",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srcs := writeFiles(t, tc.files)
+			out := filepath.Join(t.TempDir(), "lib_test")
+			args := []string{"-p", "example.com/lib", "-o", out}
+			if tc.vet != "" {
+				args = append(args, "-vet", tc.vet)
+			}
+			output, err := env.run("test", append(args, srcs...)...)
+			if tc.badFile == "" {
+				if err != nil {
+					t.Fatalf("%v:\n%s", err, output)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("test succeeded; want vet finding in %s", tc.badFile)
+			}
+			if !strings.Contains(output, "vet found problems") || !strings.Contains(output, tc.badFile+":") || !strings.Contains(output, "(printf)") {
+				t.Errorf("got output:\n%s\nwant printf finding in %s", output, tc.badFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testWorker runs runWorker in the background, connected to the test by pipes.
+type testWorker struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	enc  *json.Encoder
+	dec  *json.Decoder
+	done chan error
+}
+
+func startTestWorker(t *testing.T) *testWorker {
+	// runWorker redirects standard output to standard error.
+	stdout := os.Stdout
+	t.Cleanup(func() { os.Stdout = stdout })
+
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	w := &testWorker{
+		t:    t,
+		in:   inw,
+		enc:  json.NewEncoder(inw),
+		dec:  json.NewDecoder(outr),
+		done: make(chan error, 1),
+	}
+	go func() {
+		w.done <- runWorker(inr, outw)
+		outw.Close()
+	}()
+	return w
+}
+
+func (w *testWorker) send(req workRequest) {
+	w.t.Helper()
+	if err := w.enc.Encode(req); err != nil {
+		w.t.Fatal(err)
+	}
+}
+
+func (w *testWorker) receive() workResponse {
+	w.t.Helper()
+	var resp workResponse
+	if err := w.dec.Decode(&resp); err != nil {
+		w.t.Fatal(err)
+	}
+	return resp
+}
+
+// stop closes the worker's input and waits for it to exit.
+func (w *testWorker) stop() {
+	w.t.Helper()
+	w.in.Close()
+	if err := <-w.done; err != nil {
+		w.t.Fatal(err)
+	}
+}
+
+// stdImportcfgArgs returns arguments for a request that writes an importcfg
+// for a fake standard library with one package. The stdimportcfg verb is
+// quick and doesn't need any tools.
+func stdImportcfgArgs(t *testing.T, outPath string) []string {
+	stdlibDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(stdlibDir, "fmt.a"), []byte("!<arch>\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return []string{"stdimportcfg", "-stdlib", stdlibDir, "-o", outPath}
+}
+
+func TestWorkerSingleplex(t *testing.T) {
+	w := startTestWorker(t)
+	dir := t.TempDir()
+	for _, name := range []string{"a.importcfg", "b.importcfg"} {
+		outPath := filepath.Join(dir, name)
+		w.send(workRequest{Arguments: stdImportcfgArgs(t, outPath)})
+		resp := w.receive()
+		if resp.ExitCode != 0 || resp.RequestID != 0 {
+			t.Fatalf("got response %+v; want exit code 0 for request 0", resp)
+		}
+		if data, err := os.ReadFile(outPath); err != nil {
+			t.Fatal(err)
+		} else if !strings.HasPrefix(string(data), "packagefile fmt=") {
+			t.Errorf("%s: got %q; want packagefile line for fmt", name, data)
+		}
+	}
+	w.stop()
+}
+
+// TestWorkerMultiplexOutOfOrder checks that requests with IDs are handled
+// concurrently: a request that's blocked doesn't hold up a later one.
+// The first request's params file is a FIFO, so reading it blocks until
+// the test writes to it.
+func TestWorkerMultiplexOutOfOrder(t *testing.T) {
+	dir := t.TempDir()
+	paramsPath := filepath.Join(dir, "params")
+	makeFIFO(t, paramsPath)
+
+	w := startTestWorker(t)
+	w.send(workRequest{Arguments: []string{"@" + paramsPath}, RequestID: 1})
+	w.send(workRequest{Arguments: stdImportcfgArgs(t, filepath.Join(dir, "2.importcfg")), RequestID: 2})
+	if resp := w.receive(); resp.RequestID != 2 || resp.ExitCode != 0 {
+		t.Fatalf("got response %+v; want exit code 0 for request 2", resp)
+	}
+
+	params := strings.Join(stdImportcfgArgs(t, filepath.Join(dir, "1.importcfg")), "\n") + "\n"
+	if err := os.WriteFile(paramsPath, []byte(params), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if resp := w.receive(); resp.RequestID != 1 || resp.ExitCode != 0 {
+		t.Fatalf("got response %+v; want exit code 0 for request 1", resp)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.importcfg")); err != nil {
+		t.Error(err)
+	}
+	w.stop()
+}
+
+func TestWorkerFailure(t *testing.T) {
+	w := startTestWorker(t)
+	w.send(workRequest{Arguments: []string{"stdimportcfg", "-stdlib", filepath.Join(t.TempDir(), "missing"), "-o", "out"}, RequestID: 3})
+	resp := w.receive()
+	if resp.RequestID != 3 || resp.ExitCode == 0 {
+		t.Fatalf("got response %+v; want non-zero exit code for request 3", resp)
+	}
+	if !strings.Contains(resp.Output, "stdimportcfg: error: listing std paths") {
+		t.Errorf("got output %q; want error listing std paths", resp.Output)
+	}
+
+	w.send(workRequest{Arguments: []string{"stdimportcfg"}, RequestID: 4, SandboxDir: "sandbox"})
+	resp = w.receive()
+	if resp.RequestID != 4 || resp.ExitCode == 0 || !strings.Contains(resp.Output, "not supported") {
+		t.Errorf("got response %+v; want sandboxed request 4 to fail as not supported", resp)
+	}
+	w.stop()
+}
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache[string, int](2)
+	c.add("a", 1)
+	c.add("b", 2)
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Fatalf("get(a) = %d, %t; want 1, true", v, ok)
+	}
+	// b is now the least recently used entry, so it's evicted.
+	c.add("c", 3)
+	if _, ok := c.get("b"); ok {
+		t.Error("get(b) found evicted entry")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if v, ok := c.get(key); !ok || v != want {
+			t.Errorf("get(%s) = %d, %t; want %d, true", key, v, ok, want)
+		}
+	}
+	if n := c.len(); n != 2 {
+		t.Errorf("len() = %d; want 2", n)
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+#ifndef CGO_DEP_H_
+#define CGO_DEP_H_
+
+int square(int x);
+
+#endif
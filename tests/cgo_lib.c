@@ -0,0 +1,8 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+#include "cgo_lib.h"
+
+int add(int a, int b) { return a + b; }
@@ -0,0 +1,20 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package cgo_lib
+
+// #include "cgo_lib.h"
+// #include "tests/cgo_dep.h"
+import "C"
+
+// Add returns the sum of two integers, computed in C.
+func Add(a, b int) int {
+	return int(C.add(C.int(a), C.int(b)))
+}
+
+// Square returns the square of an integer, computed in a C library.
+func Square(x int) int {
+	return int(C.square(C.int(x)))
+}
@@ -0,0 +1,23 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package cgo_test
+
+import (
+	"rules_go_simple/tests/cgo_lib"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	if got := cgo_lib.Add(2, 3); got != 5 {
+		t.Errorf("got %d; want 5", got)
+	}
+}
+
+func TestSquare(t *testing.T) {
+	if got := cgo_lib.Square(4); got != 16 {
+		t.Errorf("got %d; want 16", got)
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"debug/elf"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestCrossBinary(t *testing.T) {
+	binPath := "./" + strings.TrimPrefix(flag.Args()[0], "tests/")
+	f, err := elf.Open(binPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Machine != elf.EM_AARCH64 {
+		t.Errorf("got machine %v; want %v", f.Machine, elf.EM_AARCH64)
+	}
+}
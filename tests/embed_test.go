@@ -0,0 +1,67 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package embed_test
+
+import (
+	"embed"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+)
+
+//go:embed embed_data/a.txt
+var aTxt string
+
+//go:embed embed_data
+var data embed.FS
+
+//go:embed all:embed_data
+var allData embed.FS
+
+func TestEmbedFile(t *testing.T) {
+	if got, want := strings.TrimSpace(aTxt), "a"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestEmbedDir(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		fsys embed.FS
+		want []string
+	}{
+		{
+			name: "default",
+			fsys: data,
+			want: []string{"embed_data/a.txt", "embed_data/sub/b.txt"},
+		},
+		{
+			name: "all",
+			fsys: allData,
+			want: []string{"embed_data/_hidden.txt", "embed_data/a.txt", "embed_data/sub/b.txt"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			err := fs.WalkDir(test.fsys, ".", func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.IsDir() {
+					got = append(got, path)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
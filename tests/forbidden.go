@@ -0,0 +1,38 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+// Package forbidden provides an analyzer for testing nogo. It reports calls
+// to functions named Forbidden.
+package forbidden
+
+import (
+	"go/ast"
+	"go/token"
+
+	"rules_go_simple/tests/unitchecker"
+)
+
+var Analyzer = &unitchecker.Analyzer{
+	Name: "forbidden",
+	Run: func(file *ast.File, report func(pos token.Pos, message string)) {
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			var name string
+			switch fun := call.Fun.(type) {
+			case *ast.Ident:
+				name = fun.Name
+			case *ast.SelectorExpr:
+				name = fun.Sel.Name
+			}
+			if name == "Forbidden" {
+				report(call.Pos(), "call to Forbidden")
+			}
+			return true
+		})
+	},
+}
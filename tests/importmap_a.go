@@ -0,0 +1,10 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package importmap_a
+
+import "rules_go_simple/tests/importmap_dep"
+
+func Origin() string { return importmap_dep.Origin() }
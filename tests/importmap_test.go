@@ -0,0 +1,24 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package importmap_test
+
+import (
+	"testing"
+
+	"rules_go_simple/tests/importmap_a"
+	"rules_go_simple/tests/importmap_b"
+)
+
+// TestImportmap checks that two packages with the same import path but
+// different importmaps can be linked into the same binary.
+func TestImportmap(t *testing.T) {
+	if got, want := importmap_a.Origin(), "upstream"; got != want {
+		t.Errorf("importmap_a.Origin() = %q; want %q", got, want)
+	}
+	if got, want := importmap_b.Origin(), "vendored"; got != want {
+		t.Errorf("importmap_b.Origin() = %q; want %q", got, want)
+	}
+}
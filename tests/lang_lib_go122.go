@@ -0,0 +1,18 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+//go:build go1.22
+
+package lang_lib
+
+// Sum returns the sum of integers in [0, n). It uses range-over-int,
+// added in Go 1.22, which is allowed because of the constraint above.
+func Sum(n int) int {
+	s := 0
+	for i := range n {
+		s += i
+	}
+	return s
+}
@@ -0,0 +1,18 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package lang_test
+
+import (
+	"testing"
+
+	"rules_go_simple/tests/lang_lib"
+)
+
+func TestSum(t *testing.T) {
+	if got, want := lang_lib.Sum(4), 6; got != want {
+		t.Errorf("got %d; want %d", got, want)
+	}
+}
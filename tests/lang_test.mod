@@ -0,0 +1,3 @@
+module rules_go_simple/tests
+
+go 1.21.0
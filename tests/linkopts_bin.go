@@ -0,0 +1,15 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import "fmt"
+
+// message is set with the linker's -X flag.
+var message = "default"
+
+func main() {
+	fmt.Println(message)
+}
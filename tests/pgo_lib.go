@@ -0,0 +1,16 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package pgo_lib
+
+// Sum returns the sum of the integers from 0 to n-1. It's hot in the
+// profile used by pgo_test.
+func Sum(n int) int {
+	s := 0
+	for i := 0; i < n; i++ {
+		s += i
+	}
+	return s
+}
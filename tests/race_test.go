@@ -0,0 +1,18 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package race_test
+
+import (
+	"testing"
+
+	"rules_go_simple/tests/race_lib"
+)
+
+func TestRaceEnabled(t *testing.T) {
+	if !race_lib.Enabled {
+		t.Error("race_lib was not built with the race detector")
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package tags
+
+import "testing"
+
+func TestTagged(t *testing.T) {
+	if !Tagged {
+		t.Error("file with rules_go_simple_tag constraint was not compiled")
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+package main
+
+import (
+	"fmt"
+	"internal/coverage"
+	"internal/coverage/encodecounter"
+	"internal/coverage/encodemeta"
+	"internal/coverage/slicewriter"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteLcov(t *testing.T) {
+	dir := t.TempDir()
+	profilePath := filepath.Join(dir, "coverprofile")
+	lcovPath := filepath.Join(dir, "lcov")
+	profile := `mode: count
+example.com/a/a.go:3.14,5.2 2 4
+example.com/a/a.go:5.2,6.3 1 1
+example.com/a/a.go:8.10,9.2 1 0
+`
+	if err := os.WriteFile(profilePath, []byte(profile), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLcov(profilePath, "", lcovPath); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(lcovPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Line 5 is covered by two blocks. It gets the higher count.
+	want := `SF:example.com/a/a.go
+DA:3,4
+DA:4,4
+DA:5,4
+DA:6,1
+DA:8,0
+DA:9,0
+LH:4
+LF:6
+end_of_record
+`
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLineCountsMerge(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		want int
+	}{
+		{mode: "set", want: 1},
+		{mode: "count", want: 5},
+		{mode: "atomic", want: 5},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			counts := make(lineCounts)
+			for _, count := range []int{2, 3} {
+				if tc.mode == "set" {
+					count = 1
+				}
+				run := make(lineCounts)
+				run.add("a.go", 1, 1, count)
+				counts.merge(run, tc.mode)
+			}
+			if got := counts["a.go"][1]; got != tc.want {
+				t.Errorf("got %d; want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestWriteLcovCoverDir checks that coverage data written to GOCOVERDIR by
+// two instrumented binaries, one of which ran twice, is merged with the
+// test's own profile.
+func TestWriteLcovCoverDir(t *testing.T) {
+	// F has three blocks: lines 3-4, line 6, and line 8. The test's
+	// profile only covers the first.
+	for _, tc := range []struct {
+		mode     string
+		profile  int
+		runs     [][][]uint32 // counters for each run of each binary
+		wantLcov string
+	}{
+		{
+			mode:    "set",
+			profile: 1,
+			runs:    [][][]uint32{{{1, 0, 0}, {1, 1, 0}}, {{1, 0, 0}}},
+			wantLcov: `SF:example.com/a/a.go
+DA:3,1
+DA:4,1
+DA:6,1
+DA:8,0
+LH:3
+LF:4
+end_of_record
+`,
+		}, {
+			mode:    "count",
+			profile: 1,
+			runs:    [][][]uint32{{{2, 0, 0}, {3, 1, 0}}, {{1, 0, 0}}},
+			wantLcov: `SF:example.com/a/a.go
+DA:3,7
+DA:4,7
+DA:6,1
+DA:8,0
+LH:3
+LF:4
+end_of_record
+`,
+		}, {
+			mode:    "atomic",
+			profile: 2,
+			runs:    [][][]uint32{{{2, 0, 0}, {3, 1, 0}}, {{1, 0, 0}}},
+			wantLcov: `SF:example.com/a/a.go
+DA:3,8
+DA:4,8
+DA:6,1
+DA:8,0
+LH:3
+LF:4
+end_of_record
+`,
+		},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			dir := t.TempDir()
+			coverDir := filepath.Join(dir, "coverdir")
+			if err := os.Mkdir(coverDir, 0777); err != nil {
+				t.Fatal(err)
+			}
+			for i, runs := range tc.runs {
+				writePod(t, coverDir, byte(i+1), tc.mode, runs)
+			}
+			profilePath := filepath.Join(dir, "coverprofile")
+			profile := fmt.Sprintf("mode: %s\nexample.com/a/a.go:3.14,4.2 1 %d\n", tc.mode, tc.profile)
+			if err := os.WriteFile(profilePath, []byte(profile), 0666); err != nil {
+				t.Fatal(err)
+			}
+			lcovPath := filepath.Join(dir, "lcov")
+			if err := writeLcov(profilePath, coverDir, lcovPath); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(lcovPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.wantLcov {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.wantLcov)
+			}
+		})
+	}
+}
+
+// writePod writes a meta-data file and counter data files to dir, like an
+// instrumented binary does when it exits with GOCOVERDIR set. Each element of
+// runs holds counters for one run of function F in example.com/a/a.go.
+// id distinguishes binaries.
+func writePod(t *testing.T, dir string, id byte, mode string, runs [][]uint32) {
+	t.Helper()
+	b, err := encodemeta.NewCoverageMetaDataBuilder("example.com/a", "a", "example.com/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.AddFunc(coverage.FuncDesc{
+		Funcname: "F",
+		Srcfile:  "example.com/a/a.go",
+		Units: []coverage.CoverableUnit{
+			{StLine: 3, StCol: 14, EnLine: 4, EnCol: 2, NxStmts: 1},
+			{StLine: 6, StCol: 2, EnLine: 6, EnCol: 10, NxStmts: 1},
+			{StLine: 8, StCol: 2, EnLine: 8, EnCol: 10, NxStmts: 1},
+		},
+	})
+	blob := &slicewriter.WriteSeeker{}
+	if _, err := b.Emit(blob); err != nil {
+		t.Fatal(err)
+	}
+
+	var counterMode coverage.CounterMode
+	switch mode {
+	case "set":
+		counterMode = coverage.CtrModeSet
+	case "count":
+		counterMode = coverage.CtrModeCount
+	case "atomic":
+		counterMode = coverage.CtrModeAtomic
+	}
+	hash := [16]byte{id}
+	metaPath := filepath.Join(dir, fmt.Sprintf("%s.%x", coverage.MetaFilePref, hash))
+	writeFile(t, metaPath, func(f *os.File) error {
+		w := encodemeta.NewCoverageMetaFileWriter(metaPath, f)
+		return w.Write(hash, [][]byte{blob.BytesWritten()}, counterMode, coverage.CtrGranularityPerBlock)
+	})
+
+	for i, counters := range runs {
+		counterPath := filepath.Join(dir, fmt.Sprintf(coverage.CounterFileTempl, coverage.CounterFilePref, hash, 1000+i, i))
+		writeFile(t, counterPath, func(f *os.File) error {
+			w := encodecounter.NewCoverageDataWriter(f, coverage.CtrRaw)
+			return w.Write(hash, map[string]string{"argc": "1", "argv0": "a"}, podCounters(counters))
+		})
+	}
+}
+
+// podCounters holds counters for function 0 in package 0 of a pod.
+type podCounters []uint32
+
+func (c podCounters) VisitFuncs(f encodecounter.CounterVisitorFn) error {
+	return f(0, 0, c)
+}
+
+func writeFile(t *testing.T, path string, write func(f *os.File) error) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright Jay Conrod. All rights reserved.
+
+// This file is part of rules_go_simple. Use of this source code is governed by
+// the 3-clause BSD license that can be found in the LICENSE.txt file.
+
+// Package unitchecker is a small stand-in for
+// golang.org/x/tools/go/analysis/unitchecker, so the nogo rule can be tested
+// without depending on golang.org/x/tools. Like unitchecker, Main speaks the
+// protocol of "go vet -vettool": it reads a JSON configuration file naming
+// the package's sources and writes findings as JSON. Analyzers only see
+// parsed files, not type information.
+package unitchecker
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+)
+
+// Analyzer reports problems in a parsed file by calling report.
+type Analyzer struct {
+	Name string
+	Run  func(file *ast.File, report func(pos token.Pos, message string))
+}
+
+type diagnostic struct {
+	Posn    string `json:"posn"`
+	Message string `json:"message"`
+}
+
+// Main runs analyzers on the package described by the configuration file
+// named by the last command line argument, then exits.
+func Main(analyzers ...*Analyzer) {
+	if err := run(analyzers, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(analyzers []*Analyzer, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s [-json] file.cfg", os.Args[0])
+	}
+	data, err := os.ReadFile(args[len(args)-1])
+	if err != nil {
+		return err
+	}
+	var cfg struct {
+		ID         string
+		GoFiles    []string
+		VetxOutput string
+		Stdout     string
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+
+	fset := token.NewFileSet()
+	results := make(map[string][]diagnostic)
+	for _, path := range cfg.GoFiles {
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		for _, a := range analyzers {
+			a.Run(file, func(pos token.Pos, message string) {
+				results[a.Name] = append(results[a.Name], diagnostic{Posn: fset.Position(pos).String(), Message: message})
+			})
+		}
+	}
+
+	// This checker doesn't compute facts, but the builder expects
+	// the file to exist.
+	if cfg.VetxOutput != "" {
+		if err := os.WriteFile(cfg.VetxOutput, nil, 0666); err != nil {
+			return err
+		}
+	}
+	var out []byte
+	if len(results) > 0 {
+		if out, err = json.Marshal(map[string]map[string][]diagnostic{cfg.ID: results}); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(cfg.Stdout, out, 0666)
+}